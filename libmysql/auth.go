@@ -0,0 +1,61 @@
+package libmysql
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
+)
+
+var (
+	// the plugin named in the message of an authentication error
+	rAuthPlugin = regexp.MustCompile(`(?i)plugin '([^']+)'`)
+
+	// errors which fail authentication
+	authErrnos = map[uint16]bool{
+		1045: true, // ER_ACCESS_DENIED_ERROR
+		1251: true, // ER_NOT_SUPPORTED_AUTH_MODE
+		1524: true, // ER_PLUGIN_IS_NOT_LOADED
+		1698: true, // ER_ACCESS_DENIED_NO_PASSWORD_ERROR
+		2059: true, // CR_AUTH_PLUGIN_CANNOT_LOAD
+		2061: true, // CR_AUTH_PLUGIN_ERR
+	}
+)
+
+// AuthError is returned when connecting fails to authenticate, it unwraps to
+// the *bridge.MySQLError
+type AuthError struct {
+	// the authentication plugin involved: the one named by the server,
+	// otherwise the configured or client library's default plugin
+	Plugin string
+	Err    *bridge.MySQLError
+}
+
+func (err *AuthError) Error() string {
+	return fmt.Sprintf("Failed to authenticate with %s: %s", err.Plugin, err.Err)
+}
+
+func (err *AuthError) Unwrap() error {
+	return err.Err
+}
+
+// Wraps authentication failures from connecting in an *AuthError
+func authError(err error, cfg *Config) error {
+	var myErr *bridge.MySQLError
+	if !errors.As(err, &myErr) || !authErrnos[myErr.Errno] {
+		return err
+	}
+
+	// libmysqlclient doesn't report the plugin the server switched to, so
+	// unless the message names it this is the one the client started with
+	plugin := cfg.DefaultAuth
+	if plugin == "" {
+		plugin = bridge.DefaultAuthPlugin()
+	}
+	if match := rAuthPlugin.FindStringSubmatch(myErr.Message); match != nil {
+		plugin = match[1]
+	}
+
+	return &AuthError{Plugin: plugin, Err: myErr}
+}
@@ -0,0 +1,33 @@
+package libmysql
+
+import (
+	"errors"
+
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
+	. "gopkg.in/check.v1"
+)
+
+type AuthSuite struct{}
+
+var _ = Suite(&AuthSuite{})
+
+func (s *AuthSuite) TestAuthError(c *C) {
+	cfg := &Config{DefaultAuth: "caching_sha2_password"}
+
+	cannotLoad := &bridge.MySQLError{Errno: 2059, Message: "Authentication plugin 'auth_gssapi_client' cannot be loaded"}
+	err := authError(cannotLoad, cfg)
+
+	var authErr *AuthError
+	c.Assert(errors.As(err, &authErr), Equals, true)
+	c.Assert(authErr.Plugin, Equals, "auth_gssapi_client")
+	c.Assert(errors.Is(err, cannotLoad), Equals, true)
+	c.Assert(err, ErrorMatches, "Failed to authenticate with auth_gssapi_client: Error 2059: .*")
+
+	denied := &bridge.MySQLError{Errno: 1045, Message: "Access denied for user 'app'@'localhost'"}
+	c.Assert(authError(denied, cfg), ErrorMatches, "Failed to authenticate with caching_sha2_password: Error 1045: .*")
+	c.Assert(authError(denied, &Config{}), ErrorMatches, "Failed to authenticate with "+bridge.DefaultAuthPlugin()+": .*")
+	c.Assert(bridge.DefaultAuthPlugin(), Matches, "caching_sha2_password|mysql_native_password")
+
+	gone := &bridge.MySQLError{Errno: 2003, Message: "Can't connect to MySQL server"}
+	c.Assert(authError(gone, cfg), Equals, gone)
+}
@@ -0,0 +1,102 @@
+package libmysql
+
+import (
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
+)
+
+// Backend is a single connection to a MySQL server.  Conns use libmysqlclient
+// by default, other backends (such as Fake) can be provided with
+// NewConnector.
+//
+// A Backend is used by one Conn at a time.  Query leaves a streaming result
+// set open, which must be read to the end with FetchRow or discarded with
+// Flush before the next command.
+type Backend interface {
+	// Connect is called before any other method, and again to try another
+	// host when it fails to reach the server
+	Connect(host string, port int, user, pass, database string, opts *bridge.Options) error
+
+	// Query runs a query and opens a streaming result set
+	Query(query string) error
+	// Execute runs a query and discards any result set
+	Execute(query string) error
+
+	// the columns of the current result set
+	Fields() []bridge.MySQLField
+	// FetchRow returns the next row of the streaming result set, or nil at
+	// the end of the result set.  NULL fields are nil.
+	FetchRow() (*[][]byte, error)
+	// Flush discards the rest of the streaming result set
+	Flush() error
+
+	// the results of the last Execute
+	RowsAffected() int64
+	LastInsertID() int64
+
+	// Escape escapes the contents of a string literal
+	Escape(val string) string
+
+	Close() error
+}
+
+// StoredResult is a result set which has been read entirely into client memory
+type StoredResult interface {
+	Fields() []bridge.MySQLField
+	NumRows() int64
+	// DataSeek positions the result on the row at the zero based offset
+	DataSeek(row int64)
+	// FetchRow returns the next row, or nil at the end of the result set
+	FetchRow() *[][]byte
+	Free()
+}
+
+// BufferingBackend is implemented by backends which support buffered queries
+type BufferingBackend interface {
+	Backend
+
+	// QueryBuffered runs a query and reads its entire result set, leaving
+	// the connection free for other commands
+	QueryBuffered(query string) (StoredResult, error)
+}
+
+// implemented by backends which can fetch many rows of a streaming result
+// set in one call
+type batchFetcher interface {
+	FetchRows(rb *bridge.RowBatch) (int, error)
+}
+
+// implemented by backends which report the charset of the connection
+type charsetBackend interface {
+	Charset() string
+}
+
+// implemented by backends which can reset the session of the connection
+type resetBackend interface {
+	Reset() error
+}
+
+// the libmysqlclient backend
+type bridgeBackend struct {
+	*bridge.Bridge
+}
+
+func newBridgeBackend() Backend {
+	return &bridgeBackend{}
+}
+
+func (b *bridgeBackend) Connect(host string, port int, user, pass, database string, opts *bridge.Options) (err error) {
+	b.Bridge, err = bridge.NewBridge(host, port, user, pass, database, opts)
+	return err
+}
+
+func (b *bridgeBackend) QueryBuffered(query string) (StoredResult, error) {
+	res, err := b.Bridge.QueryBuffered(query)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (b *bridgeBackend) Escape(val string) string {
+	return b.Bridge.EscapeString(val)
+}
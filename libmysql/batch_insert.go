@@ -0,0 +1,188 @@
+package libmysql
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/carlsverre/go-libmysql/libmysql/escape"
+)
+
+const (
+	// room left in each packet for the protocol header and command byte
+	packetOverhead = 1024
+)
+
+var (
+	errRowTooLarge = errors.New("Row does not fit within max_allowed_packet")
+)
+
+// Execer is implemented by *sql.DB, *sql.Conn and *sql.Tx
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// BatchChunk describes a single multi-row INSERT sent by a BatchInserter
+type BatchChunk struct {
+	Rows          int
+	RowsAffected  int64
+	FirstInsertID int64
+}
+
+// BatchInserter accumulates rows and writes them using multi-row INSERT
+// statements, each of which fits within the server's max_allowed_packet
+type BatchInserter struct {
+	db           Execer
+	prefix       string
+	suffix       string
+	numColumns   int
+	maxStatement int
+
+	buf     bytes.Buffer
+	row     bytes.Buffer
+	pending int
+
+	rowsAffected int64
+	chunks       []BatchChunk
+}
+
+// NewBatchInserter creates a BatchInserter which writes into the provided
+// table and columns.  onDuplicate is an optional ON DUPLICATE KEY UPDATE
+// clause, for example "foo = VALUES(foo)".
+func NewBatchInserter(db Execer, table string, columns []string, onDuplicate string) *BatchInserter {
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = escape.EscapeIdentifier(col)
+	}
+
+	b := &BatchInserter{
+		db:         db,
+		numColumns: len(columns),
+		prefix: fmt.Sprintf("INSERT INTO %s (%s) VALUES ",
+			quoteTable(table), strings.Join(quoted, ", ")),
+	}
+
+	if onDuplicate != "" {
+		b.suffix = " ON DUPLICATE KEY UPDATE " + onDuplicate
+	}
+
+	return b
+}
+
+// Quotes each part of a table name which may be qualified with its database
+func quoteTable(table string) string {
+	parts := strings.Split(table, ".")
+	for i, part := range parts {
+		parts[i] = escape.EscapeIdentifier(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// SetMaxPacketSize overrides the statement size limit, which otherwise
+// defaults to the server's max_allowed_packet
+func (b *BatchInserter) SetMaxPacketSize(size int) {
+	b.maxStatement = size - packetOverhead
+}
+
+// Add queues a row for insertion, flushing the pending rows first if the
+// row would not fit in the current statement
+func (b *BatchInserter) Add(values ...interface{}) error {
+	if len(values) != b.numColumns {
+		return fmt.Errorf("Expected %d values, got %d", b.numColumns, len(values))
+	}
+
+	if b.maxStatement == 0 {
+		var maxPacket int
+		if err := b.db.QueryRow("SELECT @@max_allowed_packet").Scan(&maxPacket); err != nil {
+			return err
+		}
+		b.SetMaxPacketSize(maxPacket)
+	}
+
+	b.row.Reset()
+	b.row.WriteByte('(')
+	for i, val := range values {
+		v, err := driver.DefaultParameterConverter.ConvertValue(val)
+		if err != nil {
+			return err
+		}
+
+		out, err := escape.Escape(v)
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			b.row.WriteString(", ")
+		}
+		b.row.WriteString(out)
+	}
+	b.row.WriteByte(')')
+
+	if len(b.prefix)+b.row.Len()+len(b.suffix) > b.maxStatement {
+		return errRowTooLarge
+	}
+
+	if b.pending > 0 && b.buf.Len()+2+b.row.Len()+len(b.suffix) > b.maxStatement {
+		if err := b.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if b.pending == 0 {
+		b.buf.WriteString(b.prefix)
+	} else {
+		b.buf.WriteString(", ")
+	}
+	b.buf.Write(b.row.Bytes())
+	b.pending++
+
+	return nil
+}
+
+// Flush writes any pending rows to the database
+func (b *BatchInserter) Flush() error {
+	if b.pending == 0 {
+		return nil
+	}
+
+	// the statement is already escaped, so protect any literal % from the
+	// driver's own formatting
+	query := strings.Replace(b.buf.String()+b.suffix, "%", "%%", -1)
+	chunk := BatchChunk{Rows: b.pending}
+
+	// the rows stay pending if the statement fails, so Flush can be retried
+	res, err := b.db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	b.buf.Reset()
+	b.pending = 0
+
+	if chunk.RowsAffected, err = res.RowsAffected(); err != nil {
+		return err
+	}
+	if chunk.FirstInsertID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+
+	b.rowsAffected += chunk.RowsAffected
+	b.chunks = append(b.chunks, chunk)
+
+	return nil
+}
+
+// RowsAffected returns the total rows affected by all flushed statements
+func (b *BatchInserter) RowsAffected() int64 {
+	return b.rowsAffected
+}
+
+// Chunks returns a description of every statement flushed so far
+func (b *BatchInserter) Chunks() []BatchChunk {
+	return b.chunks
+}
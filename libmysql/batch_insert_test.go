@@ -0,0 +1,123 @@
+package libmysql
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type BatchSuite struct{}
+
+var _ = Suite(&BatchSuite{})
+
+type fakeResult struct {
+	rowsAffected, lastInsertId int64
+}
+
+func (r fakeResult) LastInsertId() (int64, error) { return r.lastInsertId, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+// records executed statements, each one inserting a row per "(" after the
+// column list
+type fakeExecer struct {
+	queries []string
+	nextId  int64
+	// returned by the next Exec
+	err error
+}
+
+func (e *fakeExecer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if err := e.err; err != nil {
+		e.err = nil
+		return nil, err
+	}
+
+	e.queries = append(e.queries, query)
+	rows := int64(strings.Count(query, "(") - 1)
+	res := fakeResult{rows, e.nextId + 1}
+	e.nextId += rows
+	return res, nil
+}
+
+func (e *fakeExecer) QueryRow(query string, args ...interface{}) *sql.Row {
+	panic("max_allowed_packet should not be queried")
+}
+
+func (s *BatchSuite) TestStatement(c *C) {
+	e := &fakeExecer{}
+	b := NewBatchInserter(e, "x", []string{"id", "foo"}, "foo = VALUES(foo)")
+	b.SetMaxPacketSize(1 << 20)
+
+	c.Assert(b.Add(1, "it's 100%"), IsNil)
+	c.Assert(b.Add(2, nil), IsNil)
+	c.Assert(b.Flush(), IsNil)
+
+	c.Assert(e.queries, DeepEquals, []string{
+		"INSERT INTO `x` (`id`, `foo`) VALUES (1, 'it\\'s 100%%'), (2, NULL) ON DUPLICATE KEY UPDATE foo = VALUES(foo)",
+	})
+
+	// nothing pending
+	c.Assert(b.Flush(), IsNil)
+	c.Assert(e.queries, HasLen, 1)
+
+	c.Assert(b.Add(1), Not(IsNil))
+}
+
+func (s *BatchSuite) TestQualifiedTable(c *C) {
+	e := &fakeExecer{}
+	b := NewBatchInserter(e, "gotests.x", []string{"foo"}, "")
+	b.SetMaxPacketSize(1 << 20)
+
+	c.Assert(b.Add(1), IsNil)
+	c.Assert(b.Flush(), IsNil)
+	c.Assert(e.queries, DeepEquals, []string{"INSERT INTO `gotests`.`x` (`foo`) VALUES (1)"})
+}
+
+func (s *BatchSuite) TestFlushError(c *C) {
+	failed := errors.New("failed")
+	e := &fakeExecer{err: failed}
+	b := NewBatchInserter(e, "x", []string{"foo"}, "foo = VALUES(foo)")
+	b.SetMaxPacketSize(1 << 20)
+
+	c.Assert(b.Add(1), IsNil)
+	c.Assert(b.Flush(), Equals, failed)
+
+	// the rows are still pending
+	c.Assert(b.Add(2), IsNil)
+	c.Assert(b.Flush(), IsNil)
+	c.Assert(e.queries, DeepEquals, []string{
+		"INSERT INTO `x` (`foo`) VALUES (1), (2) ON DUPLICATE KEY UPDATE foo = VALUES(foo)",
+	})
+	c.Assert(b.Chunks(), HasLen, 1)
+	c.Assert(b.Chunks()[0].Rows, Equals, 2)
+}
+
+func (s *BatchSuite) TestChunking(c *C) {
+	e := &fakeExecer{}
+	b := NewBatchInserter(e, "x", []string{"foo"}, "")
+	b.SetMaxPacketSize(packetOverhead + 100)
+
+	value := strings.Repeat("a", 20)
+	for i := 0; i < 10; i++ {
+		c.Assert(b.Add(value), IsNil)
+	}
+	c.Assert(b.Flush(), IsNil)
+
+	for _, query := range e.queries {
+		c.Assert(len(query) <= 100, Equals, true)
+	}
+
+	total := 0
+	for _, chunk := range b.Chunks() {
+		total += chunk.Rows
+		c.Assert(chunk.RowsAffected, Equals, int64(chunk.Rows))
+	}
+	c.Assert(total, Equals, 10)
+	c.Assert(len(b.Chunks()) > 1, Equals, true)
+	c.Assert(b.Chunks()[1].FirstInsertID, Equals, int64(b.Chunks()[0].Rows+1))
+	c.Assert(b.RowsAffected(), Equals, int64(10))
+
+	c.Assert(b.Add(strings.Repeat("a", 100)), Equals, errRowTooLarge)
+}
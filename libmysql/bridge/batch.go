@@ -0,0 +1,129 @@
+package bridge
+
+/*
+#include <stdlib.h>
+#include "bridge.h"
+*/
+import "C"
+import "unsafe"
+
+const (
+	nullOffset = ^C.ulong(0)
+
+	// initial size of a RowBatch buffer
+	defaultBatchBuffer = 64 * 1024
+
+	// a buffer grown past this to fit a large row is released on Reset,
+	// rather than kept by the connection for as long as it is pooled
+	maxBatchBuffer = 1024 * 1024
+)
+
+// RowBatch holds a set of rows fetched with a single call to FetchRows.  Its
+// buffers are reused by every call, so fields are only valid until the next
+// fetch.
+type RowBatch struct {
+	maxRows int
+	nFields int
+	numRows int
+
+	buf     []byte
+	offsets []C.ulong
+	lengths []C.ulong
+}
+
+// Creates a RowBatch which fetches up to maxRows rows at a time
+func NewRowBatch(maxRows int) *RowBatch {
+	return &RowBatch{
+		maxRows: maxRows,
+		buf:     make([]byte, defaultBatchBuffer),
+	}
+}
+
+// Returns the number of rows in the batch
+func (rb *RowBatch) Len() int {
+	return rb.numRows
+}
+
+// Empties the batch, so that rows left over from a result which was closed
+// early are not returned for the next one
+func (rb *RowBatch) Reset() {
+	rb.numRows = 0
+	if len(rb.buf) > maxBatchBuffer {
+		rb.buf = make([]byte, defaultBatchBuffer)
+	}
+}
+
+// Returns the contents of a field, or nil if the field is NULL
+func (rb *RowBatch) Field(row, col int) []byte {
+	idx := row*rb.nFields + col
+	offset := rb.offsets[idx]
+	if offset == nullOffset {
+		return nil
+	}
+
+	end := offset + rb.lengths[idx]
+	return rb.buf[offset:end:end]
+}
+
+// Fills the batch with up to its maximum number of rows from the current
+// streaming result.  Returns the number of rows fetched, which is zero once
+// the result set is exhausted.
+func (b *Bridge) FetchRows(rb *RowBatch) (int, error) {
+	if err := b.guard.acquire("FetchRows", StateStreaming); err != nil {
+		return 0, err
+	}
+	defer b.guard.release(StateStreaming)
+
+	nFields := int(b.h.num_fields)
+	rb.numRows = 0
+	rb.nFields = nFields
+
+	if nFields == 0 {
+		return 0, nil
+	}
+
+	if size := rb.maxRows * nFields; len(rb.offsets) < size {
+		rb.offsets = make([]C.ulong, size)
+		rb.lengths = make([]C.ulong, size)
+	}
+
+	for {
+		var (
+			n      C.int
+			needed C.ulong
+			wait   C.int
+			err    error
+		)
+
+		fetch := func(ready C.int) C.int {
+			n = C.m_fetch_rows(b.h,
+				(*C.char)(unsafe.Pointer(&rb.buf[0])), C.ulong(len(rb.buf)),
+				&rb.offsets[0], &rb.lengths[0],
+				C.int(rb.maxRows), &needed, ready, &wait)
+
+			if n > 0 && wait != 0 {
+				// return the rows we have, and finish waiting on the next fetch
+				b.fetchWait = wait
+				return 0
+			}
+			return wait
+		}
+
+		b.call(b.resumeFetch(fetch), fetch, func() {
+			if n < 0 {
+				err = b.lastError()
+			}
+		})
+
+		if err != nil {
+			return 0, err
+		} else if n == 0 && needed > 0 {
+			// the next row is larger than the whole buffer
+			rb.buf = make([]byte, int(needed))
+			continue
+		}
+
+		rb.numRows = int(n)
+		return rb.numRows, nil
+	}
+}
@@ -0,0 +1,27 @@
+package bridge
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type RowBatchSuite struct{}
+
+var _ = Suite(&RowBatchSuite{})
+
+func (s *RowBatchSuite) TestReset(c *C) {
+	rb := NewRowBatch(8)
+	rb.numRows = 3
+	rb.Reset()
+	c.Assert(rb.Len(), Equals, 0)
+	c.Assert(len(rb.buf), Equals, defaultBatchBuffer)
+
+	// grown to fit a row, but not past the limit
+	rb.buf = make([]byte, maxBatchBuffer)
+	rb.Reset()
+	c.Assert(len(rb.buf), Equals, maxBatchBuffer)
+
+	// grown to fit a large row
+	rb.buf = make([]byte, 100*1024*1024)
+	rb.Reset()
+	c.Assert(len(rb.buf), Equals, defaultBatchBuffer)
+}
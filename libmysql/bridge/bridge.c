@@ -1,5 +1,11 @@
 #include "bridge.h"
+#include "_cgo_export.h"
 #include <stdio.h>
+#include <string.h>
+
+#ifdef M_ASYNC
+#include <poll.h>
+#endif
 
 void m_init() {
 	mysql_library_init(0, 0, 0);
@@ -9,20 +15,138 @@ int m_escape_string(char *out, char *in, unsigned long length) {
 	return mysql_escape_string(out, in, length);
 }
 
-int m_connect(M_HANDLE *conn, const char *host, unsigned int port, const char *user, const char *pass, const char *database) {
-	MYSQL *c;
+// the plugin the client authenticates with when none is configured
+const char *m_default_auth() {
+#if defined(M_ASYNC) || defined(MARIADB_BASE_VERSION) || defined(MARIADB_PACKAGE_VERSION)
+	return "mysql_native_password";
+#else
+	return mysql_get_client_version() >= 80000 ? "caching_sha2_password" : "mysql_native_password";
+#endif
+}
+
+int m_real_escape_string(M_HANDLE *conn, char *out, char *in, unsigned long length) {
+	// a backslash is an ordinary character with NO_BACKSLASH_ESCAPES, so the
+	// only way to escape a quote is to double it.  No charset the client
+	// accepts uses 0x27 within a multibyte character.
+	if (conn->mysql->server_status & SERVER_STATUS_NO_BACKSLASH_ESCAPES) {
+		unsigned long i, n = 0;
+		for (i = 0; i < length; i++) {
+			if (in[i] == '\'') {
+				out[n++] = '\'';
+			}
+			out[n++] = in[i];
+		}
+		out[n] = 0;
+		return n;
+	}
 
-	mysql_thread_init();
+	return mysql_real_escape_string(conn->mysql, out, in, length);
+}
 
+int m_open(M_HANDLE *conn) {
 	conn->mysql = mysql_init(0);
-	return !mysql_real_connect(conn->mysql, host, user, pass, database, port, 0, 0);
+	if (conn->mysql == 0) {
+		return 1;
+	}
+
+#ifdef M_ASYNC
+	// enable the _start/_cont api
+	if (mysql_options(conn->mysql, MYSQL_OPT_NONBLOCK, 0) != 0) {
+		return 1;
+	}
+#endif
+
+	return 0;
+}
+
+int m_connect_start(M_HANDLE *conn, const char *host, unsigned int port, const char *user, const char *pass, const char *database) {
+#ifdef M_ASYNC
+	MYSQL *ret = 0;
+	int status = mysql_real_connect_start(&ret, conn->mysql, host, user, pass, database, port, 0, 0);
+
+	conn->ret = !ret;
+	return status;
+#else
+	conn->ret = !mysql_real_connect(conn->mysql, host, user, pass, database, port, 0, 0);
+	return 0;
+#endif
+}
+
+int m_connect_cont(M_HANDLE *conn, int ready) {
+#ifdef M_ASYNC
+	MYSQL *ret = 0;
+	int status = mysql_real_connect_cont(&ret, conn->mysql, ready);
+
+	conn->ret = !ret;
+	return status;
+#else
+	return 0;
+#endif
+}
+
+int m_reset_start(M_HANDLE *conn) {
+#ifdef M_ASYNC
+	int ret = 0;
+	int status = mysql_reset_connection_start(&ret, conn->mysql);
+
+	conn->ret = ret;
+	return status;
+#else
+	conn->ret = mysql_reset_connection(conn->mysql);
+	return 0;
+#endif
+}
+
+int m_reset_cont(M_HANDLE *conn, int ready) {
+#ifdef M_ASYNC
+	int ret = 0;
+	int status = mysql_reset_connection_cont(&ret, conn->mysql, ready);
+
+	conn->ret = ret;
+	return status;
+#else
+	return 0;
+#endif
 }
 
-void m_close(M_HANDLE *conn) {
-	if (conn->mysql) {
-		mysql_close(conn->mysql);
+int m_close_start(M_HANDLE *conn) {
+	int status = 0;
+
+	if (!conn->mysql) {
+		return 0;
+	}
+
+#ifdef M_ASYNC
+	status = mysql_close_start(conn->mysql);
+#else
+	mysql_close(conn->mysql);
+#endif
+
+	if (status == 0) {
 		conn->mysql = 0;
 	}
+	return status;
+}
+
+int m_close_cont(M_HANDLE *conn, int ready) {
+#ifdef M_ASYNC
+	int status = mysql_close_cont(conn->mysql, ready);
+
+	if (status == 0) {
+		conn->mysql = 0;
+	}
+	return status;
+#else
+	return 0;
+#endif
+}
+
+int m_set_charset(M_HANDLE *conn, const char *charset) {
+	return mysql_options(conn->mysql, MYSQL_SET_CHARSET_NAME, charset);
+}
+
+const char *m_charset(M_HANDLE *conn) {
+	return mysql_character_set_name(conn->mysql);
 }
 
 int m_errno(M_HANDLE *conn) {
@@ -39,19 +163,14 @@ void m_clear_result(M_HANDLE *conn) {
 	conn->num_fields = 0;
 	conn->fields = 0;
 	conn->result = 0;
+	conn->pending_row = 0;
+	conn->pending_lengths = 0;
+	conn->fetching = 0;
 }
 
-int m_query(M_HANDLE *conn, const char *query, unsigned long len, int prep_result) {
-	m_clear_result(conn);
-
-	if (mysql_real_query(conn->mysql, query, len) != 0) {
-		return 1;
-	}
-
-	if (prep_result) {
-		conn->result = mysql_use_result(conn->mysql);
-	} else {
-		conn->result = mysql_store_result(conn->mysql);
+// inspect conn->result once the query and use/store_result have completed
+static int m_finish_query(M_HANDLE *conn) {
+	if (conn->mode != M_RESULT_STREAM) {
 		conn->affected_rows = mysql_affected_rows(conn->mysql);
 	}
 
@@ -66,7 +185,7 @@ int m_query(M_HANDLE *conn, const char *query, unsigned long len, int prep_resul
 		conn->fields = mysql_fetch_fields(conn->result);
 	}
 
-	if (!prep_result) {
+	if (conn->mode == M_RESULT_NONE && conn->result) {
 		// clear the result set for the next query
 		mysql_free_result(conn->result);
 		conn->result = 0;
@@ -75,22 +194,123 @@ int m_query(M_HANDLE *conn, const char *query, unsigned long len, int prep_resul
 	return 0;
 }
 
-void m_flush(M_HANDLE *conn) {
+#ifdef M_ASYNC
+#define M_PHASE_QUERY	0
+#define M_PHASE_STORE	1
+
+// advance a query through mysql_real_query and mysql_store_result
+static int m_query_step(M_HANDLE *conn, int status) {
+	while (status == 0) {
+		if (conn->phase == M_PHASE_STORE) {
+			conn->ret = m_finish_query(conn);
+			return 0;
+		}
+
+		if (conn->query_err) {
+			conn->ret = 1;
+			return 0;
+		}
+
+		if (conn->mode == M_RESULT_STREAM) {
+			conn->result = mysql_use_result(conn->mysql);
+			conn->ret = m_finish_query(conn);
+			return 0;
+		}
+
+		conn->phase = M_PHASE_STORE;
+		status = mysql_store_result_start(&conn->result, conn->mysql);
+	}
+
+	return status;
+}
+#endif
+
+int m_query_start(M_HANDLE *conn, const char *query, unsigned long len, int mode) {
+	m_clear_result(conn);
+	conn->mode = mode;
+
+#ifdef M_ASYNC
+	conn->phase = M_PHASE_QUERY;
+	return m_query_step(conn, mysql_real_query_start(&conn->query_err, conn->mysql, query, len));
+#else
+	if (mysql_real_query(conn->mysql, query, len) != 0) {
+		conn->ret = 1;
+		return 0;
+	}
+
+	if (mode == M_RESULT_STREAM) {
+		conn->result = mysql_use_result(conn->mysql);
+	} else {
+		conn->result = mysql_store_result(conn->mysql);
+	}
+
+	conn->ret = m_finish_query(conn);
+	return 0;
+#endif
+}
+
+int m_query_cont(M_HANDLE *conn, int ready) {
+#ifdef M_ASYNC
+	if (conn->phase == M_PHASE_STORE) {
+		return m_query_step(conn, mysql_store_result_cont(&conn->result, conn->mysql, ready));
+	}
+	return m_query_step(conn, mysql_real_query_cont(&conn->query_err, conn->mysql, ready));
+#else
+	return 0;
+#endif
+}
+
+/**
+ * Fetch the next row of the current result set into *row, which is NULL at
+ * the end of the result set or on error.  Returns 0 once *row is set,
+ * otherwise the events to wait for before calling again with ready.
+ */
+static int m_next_row(M_HANDLE *conn, MYSQL_ROW *row, int ready) {
+#ifdef M_ASYNC
+	int status;
+
+	if (conn->fetching) {
+		status = mysql_fetch_row_cont(row, conn->result, ready);
+	} else {
+		status = mysql_fetch_row_start(row, conn->result);
+	}
+
+	conn->fetching = status != 0;
+	return status;
+#else
+	*row = mysql_fetch_row(conn->result);
+	return 0;
+#endif
+}
+
+int m_flush(M_HANDLE *conn, int ready) {
+	MYSQL_ROW row;
+	int status;
+
 	if (conn->result) {
-		while (mysql_fetch_row(conn->result)) { }
+		do {
+			if ((status = m_next_row(conn, &row, ready)) != 0) {
+				return status;
+			}
+		} while (row);
+
 		mysql_free_result(conn->result);
 	}
 
 	m_clear_result(conn);
+	return 0;
 }
 
-M_ROW m_fetch_row(M_HANDLE *conn) {
-	M_ROW row = {0, 0, 0};
+M_ROW m_fetch_row(M_HANDLE *conn, int ready) {
+	M_ROW row = {0, 0, 0, 0};
 	if (conn->num_fields == 0) {
 		return row;
 	}
 
-	row.mysql_row = mysql_fetch_row(conn->result);
+	if ((row.wait = m_next_row(conn, &row.mysql_row, ready)) != 0) {
+		return row;
+	}
+
 	if (!row.mysql_row) {
 		if (mysql_errno(conn->mysql)) {
 			row.has_error = 1;
@@ -102,3 +322,219 @@ M_ROW m_fetch_row(M_HANDLE *conn) {
 
 	return row;
 }
+
+int m_fetch_rows(M_HANDLE *conn, char *buf, unsigned long buf_len, unsigned long *offsets, unsigned long *lengths, int max_rows, unsigned long *needed, int ready, int *wait) {
+	unsigned int nfields = conn->num_fields;
+	unsigned long used = 0, size;
+	unsigned long *row_lengths;
+	MYSQL_ROW row;
+	unsigned int i;
+	int nrows = 0;
+
+	*needed = 0;
+	*wait = 0;
+	if (nfields == 0) {
+		return 0;
+	}
+
+	while (nrows < max_rows) {
+		if (conn->pending_row) {
+			row = conn->pending_row;
+			row_lengths = conn->pending_lengths;
+			conn->pending_row = 0;
+			conn->pending_lengths = 0;
+		} else {
+			if ((*wait = m_next_row(conn, &row, ready)) != 0) {
+				break;
+			}
+			if (!row) {
+				if (mysql_errno(conn->mysql)) {
+					return -1;
+				}
+				break;
+			}
+			row_lengths = mysql_fetch_lengths(conn->result);
+		}
+
+		size = 0;
+		for (i = 0; i < nfields; i++) {
+			size += row_lengths[i];
+		}
+
+		if (used + size > buf_len) {
+			// the row stays valid until the next mysql_fetch_row
+			conn->pending_row = row;
+			conn->pending_lengths = row_lengths;
+			if (nrows == 0) {
+				*needed = size;
+			}
+			break;
+		}
+
+		for (i = 0; i < nfields; i++) {
+			unsigned long idx = (unsigned long)nrows * nfields + i;
+
+			lengths[idx] = row_lengths[i];
+			if (row[i]) {
+				offsets[idx] = used;
+				memcpy(buf + used, row[i], row_lengths[i]);
+				used += row_lengths[i];
+			} else {
+				offsets[idx] = M_NULL_OFFSET;
+			}
+		}
+		nrows++;
+	}
+
+	return nrows;
+}
+
+MYSQL_RES *m_detach_result(M_HANDLE *conn) {
+	MYSQL_RES *result = conn->result;
+
+	m_clear_result(conn);
+	return result;
+}
+
+M_ROW m_fetch_stored_row(MYSQL_RES *result) {
+	M_ROW row = {0, 0, 0, 0};
+
+	// stored results are entirely client side, so fetching can not fail
+	row.mysql_row = mysql_fetch_row(result);
+	if (row.mysql_row) {
+		row.lengths = mysql_fetch_lengths(result);
+	}
+
+	return row;
+}
+
+static int m_infile_init(void **ptr, const char *filename, void *userdata) {
+	uintptr_t handle = 0;
+	int res = goInfileInit((char *)filename, (uintptr_t)userdata, &handle);
+
+	*ptr = (void *)handle;
+	return res;
+}
+
+static int m_infile_read(void *ptr, char *buf, unsigned int buf_len) {
+	return goInfileRead((uintptr_t)ptr, buf, buf_len);
+}
+
+static void m_infile_end(void *ptr) {
+	goInfileEnd((uintptr_t)ptr);
+}
+
+static int m_infile_error(void *ptr, char *error_msg, unsigned int error_msg_len) {
+	return goInfileError((uintptr_t)ptr, error_msg, error_msg_len);
+}
+
+int m_enable_local_infile(M_HANDLE *conn, uintptr_t userdata) {
+	unsigned int enable = 1;
+
+	if (mysql_options(conn->mysql, MYSQL_OPT_LOCAL_INFILE, &enable) != 0) {
+		return 1;
+	}
+
+	mysql_set_local_infile_handler(conn->mysql,
+		m_infile_init, m_infile_read, m_infile_end, m_infile_error,
+		(void *)userdata);
+	return 0;
+}
+
+int m_read_option_files(M_HANDLE *conn, const char *file, const char *group) {
+	if (file && mysql_options(conn->mysql, MYSQL_READ_DEFAULT_FILE, file) != 0) {
+		return 1;
+	}
+
+	// the [client] group is read when the group is empty
+	if (mysql_options(conn->mysql, MYSQL_READ_DEFAULT_GROUP, group ? group : "") != 0) {
+		return 1;
+	}
+
+	return 0;
+}
+
+int m_set_auth_options(M_HANDLE *conn, const char *default_auth, const char *plugin_dir,
+		int cleartext, const char *server_public_key, int get_server_public_key) {
+	unsigned char enable = 1;
+
+	if (default_auth && mysql_options(conn->mysql, MYSQL_DEFAULT_AUTH, default_auth) != 0) {
+		return 1;
+	}
+	if (plugin_dir && mysql_options(conn->mysql, MYSQL_PLUGIN_DIR, plugin_dir) != 0) {
+		return 1;
+	}
+	if (cleartext && mysql_options(conn->mysql, MYSQL_ENABLE_CLEARTEXT_PLUGIN, &enable) != 0) {
+		return 1;
+	}
+	if (server_public_key && mysql_options(conn->mysql, MYSQL_SERVER_PUBLIC_KEY, server_public_key) != 0) {
+		return 1;
+	}
+	if (get_server_public_key) {
+#ifdef M_ASYNC
+		// libmariadb has no such option
+		return 1;
+#else
+		if (mysql_options(conn->mysql, MYSQL_OPT_GET_SERVER_PUBLIC_KEY, &enable) != 0) {
+			return 1;
+		}
+#endif
+	}
+
+	return 0;
+}
+
+int m_require_tls(M_HANDLE *conn) {
+#ifdef M_ASYNC
+	unsigned char enforce = 1;
+	return mysql_options(conn->mysql, MYSQL_OPT_SSL_ENFORCE, &enforce);
+#else
+	unsigned int mode = SSL_MODE_REQUIRED;
+	return mysql_options(conn->mysql, MYSQL_OPT_SSL_MODE, &mode);
+#endif
+}
+
+int m_add_init_command(M_HANDLE *conn, const char *command) {
+	return mysql_options(conn->mysql, MYSQL_INIT_COMMAND, command);
+}
+
+int m_add_connect_attr(M_HANDLE *conn, const char *key, const char *value) {
+	return mysql_options4(conn->mysql, MYSQL_OPT_CONNECT_ATTR_ADD, key, value);
+}
+
+#ifdef M_ASYNC
+int m_socket(M_HANDLE *conn) {
+	return mysql_get_socket(conn->mysql);
+}
+
+unsigned int m_timeout_ms(M_HANDLE *conn) {
+	return mysql_get_timeout_value_ms(conn->mysql);
+}
+
+int m_ready(int fd, int status) {
+	struct pollfd pfd = {fd, 0, 0};
+	int ready = 0;
+
+	if (status & MYSQL_WAIT_READ) {
+		pfd.events |= POLLIN;
+	}
+	if (status & MYSQL_WAIT_WRITE) {
+		pfd.events |= POLLOUT;
+	}
+
+	if (poll(&pfd, 1, 0) < 0) {
+		return MYSQL_WAIT_EXCEPT;
+	}
+
+	if (pfd.revents & (POLLIN | POLLHUP)) {
+		ready |= MYSQL_WAIT_READ;
+	}
+	if (pfd.revents & POLLOUT) {
+		ready |= MYSQL_WAIT_WRITE;
+	}
+	if (pfd.revents & (POLLERR | POLLNVAL)) {
+		ready |= MYSQL_WAIT_EXCEPT;
+	}
+	return ready & (status | MYSQL_WAIT_EXCEPT);
+}
+#endif
@@ -1,21 +1,103 @@
 package bridge
 
 /*
-#cgo LDFLAGS: -L/usr/lib/x86_64-linux-gnu -lmysqlclient_r -lssl -lcrypto -lpthread
-#cgo CFLAGS: -I/usr/include/mysql -O3 -g -fno-strict-aliasing -DNDEBUG -fPIC -Werror=implicit
+#cgo !libmysql_async LDFLAGS: -L/usr/lib/x86_64-linux-gnu -lmysqlclient_r -lssl -lcrypto -lpthread
+#cgo !libmysql_async CFLAGS: -I/usr/include/mysql
+#cgo libmysql_async LDFLAGS: -L/usr/lib/x86_64-linux-gnu -lmariadb -lssl -lcrypto -lpthread
+#cgo libmysql_async CFLAGS: -I/usr/include/mariadb -DM_ASYNC
+#cgo CFLAGS: -O3 -g -fno-strict-aliasing -DNDEBUG -fPIC -Werror=implicit
 
 #include <stdlib.h>
 #include "bridge.h"
 */
 import "C"
-import "unsafe"
+import (
+	"runtime"
+	"runtime/cgo"
+	"unsafe"
+)
 
 const (
 	maxSize = 1 << 20
 )
 
 type Bridge struct {
-	h C.M_HANDLE
+	*resources
+	guard   guard
+	cleanup runtime.Cleanup
+}
+
+// the C resources owned by a Bridge, kept apart from it so that they can be
+// released after the Bridge itself is garbage collected
+type resources struct {
+	h      *C.M_HANDLE
+	exec   *executor
+	poll   poller
+	infile cgo.Handle
+
+	// events a fetch is waiting on after FetchRows returned early
+	fetchWait C.int
+
+	// where the Bridge was allocated, when leak reporting is enabled
+	stack string
+}
+
+// Options holds optional connection settings which must be applied before connecting
+type Options struct {
+	// LocalInfile enables LOAD DATA LOCAL INFILE when set, and is used to
+	// open every file the server requests
+	LocalInfile InfileOpener
+
+	// ConnectAttrs are sent to the server as connection attributes
+	ConnectAttrs map[string]string
+
+	// Charset is the character set to connect with, the library's compiled
+	// default is used when empty
+	Charset string
+
+	// InitCommands are run in order once connected, connecting fails if
+	// any of them fails
+	InitCommands []string
+
+	Auth AuthOptions
+
+	// RequireTLS fails the connect when TLS can't be used
+	RequireTLS bool
+
+	// OptionFile and OptionGroup read connection defaults from a my.cnf
+	// style file, and the group within it to read besides [client].  The
+	// default option files are read when only the group is set.  Options
+	// are only taken from the files when the corresponding argument to
+	// NewBridge is empty.
+	OptionFile  string
+	OptionGroup string
+}
+
+func (opts *Options) readsOptionFiles() bool {
+	return opts.OptionFile != "" || opts.OptionGroup != ""
+}
+
+// AuthOptions configures the authentication plugins
+type AuthOptions struct {
+	// DefaultAuth is the plugin to attempt first, such as
+	// caching_sha2_password
+	DefaultAuth string
+	// PluginDir is where client plugins are loaded from
+	PluginDir string
+	// EnableCleartext allows mysql_clear_password, which sends the password
+	// unencrypted, as needed by PAM and LDAP authentication
+	EnableCleartext bool
+	// ServerPublicKey is the path to the server's RSA public key in PEM
+	// format, used by sha256_password and caching_sha2_password to send
+	// the password without TLS
+	ServerPublicKey string
+	// GetServerPublicKey requests the RSA public key from the server
+	// instead, not supported by libmariadb
+	GetServerPublicKey bool
+}
+
+func (a *AuthOptions) isSet() bool {
+	return *a != AuthOptions{}
 }
 
 type MySQLField struct {
@@ -28,6 +110,15 @@ func init() {
 	C.m_init()
 }
 
+// DefaultAuthPlugin returns the authentication plugin the client library
+// starts with when none is configured
+func DefaultAuthPlugin() string {
+	return C.GoString(C.m_default_auth())
+}
+
+// Escapes the string without knowing the connection's charset, which is
+// only safe for charsets like utf8mb4 and latin1 whose multibyte characters
+// never contain a backslash byte.  Use Bridge.EscapeString for queries.
 func EscapeString(val string) string {
 	in := C.CString(val)
 	defer C.free(unsafe.Pointer(in))
@@ -39,8 +130,18 @@ func EscapeString(val string) string {
 	return C.GoStringN(cOut, C.int(l))
 }
 
-func NewBridge(host string, port int, user, pass, database string) (*Bridge, error) {
-	bridge := new(Bridge)
+func NewBridge(host string, port int, user, pass, database string, opts *Options) (*Bridge, error) {
+	bridge := &Bridge{resources: &resources{
+		h:     (*C.M_HANDLE)(C.calloc(1, C.sizeof_M_HANDLE)),
+		exec:  newExecutor(),
+		stack: AllocationStack(),
+	}}
+	liveBridges.Add(1)
+	bridge.cleanup = runtime.AddCleanup(bridge, releaseLeakedBridge, bridge.resources)
+
+	if opts == nil {
+		opts = &Options{}
+	}
 
 	cHost := C.CString(host)
 	defer C.free(unsafe.Pointer(cHost))
@@ -50,66 +151,347 @@ func NewBridge(host string, port int, user, pass, database string) (*Bridge, err
 	cUser := C.CString(user)
 	defer C.free(unsafe.Pointer(cUser))
 
+	// an empty password is taken from the option files when reading them
 	cPass := C.CString(pass)
+	if pass == "" && opts.readsOptionFiles() {
+		C.free(unsafe.Pointer(cPass))
+		cPass = nil
+	}
 	defer C.free(unsafe.Pointer(cPass))
 
 	cDatabase := C.CString(database)
 	defer C.free(unsafe.Pointer(cDatabase))
 
-	if C.m_connect(&bridge.h, cHost, cPort, cUser, cPass, cDatabase) != 0 {
-		defer bridge.Close()
-		return nil, bridge.lastError()
+	var err error
+	bridge.exec.run(func() {
+		if C.m_open(bridge.h) != 0 {
+			err = errOutOfMemory
+			return
+		}
+
+		if opts.LocalInfile != nil {
+			bridge.infile = cgo.NewHandle(opts.LocalInfile)
+			if C.m_enable_local_infile(bridge.h, C.uintptr_t(bridge.infile)) != 0 {
+				err = errLocalInfile
+				return
+			}
+		}
+
+		if opts.Charset != "" {
+			cCharset := C.CString(opts.Charset)
+			defer C.free(unsafe.Pointer(cCharset))
+
+			if C.m_set_charset(bridge.h, cCharset) != 0 {
+				err = errCharset
+				return
+			}
+		}
+
+		if opts.readsOptionFiles() && !bridge.readOptionFiles(opts.OptionFile, opts.OptionGroup) {
+			err = errOptionFile
+			return
+		}
+
+		if opts.Auth.isSet() && !bridge.setAuthOptions(&opts.Auth) {
+			err = errAuthOptions
+			return
+		}
+
+		if opts.RequireTLS && C.m_require_tls(bridge.h) != 0 {
+			err = errRequireTLS
+			return
+		}
+
+		for _, command := range opts.InitCommands {
+			if !bridge.addInitCommand(command) {
+				err = errInitCommand
+				return
+			}
+		}
+
+		for key, val := range opts.ConnectAttrs {
+			if !bridge.addConnectAttr(key, val) {
+				err = errConnectAttr
+				return
+			}
+		}
+	})
+
+	if err == nil {
+		bridge.call(func() C.int {
+			return C.m_connect_start(bridge.h, cHost, cPort, cUser, cPass, cDatabase)
+		}, func(ready C.int) C.int {
+			return C.m_connect_cont(bridge.h, ready)
+		}, func() {
+			if bridge.h.ret != 0 {
+				err = bridge.lastError()
+			}
+		})
+	}
+
+	if err != nil {
+		bridge.Close()
+		return nil, err
 	}
 
 	return bridge, nil
 }
 
-func (b *Bridge) lastError() error {
-	if errno := C.m_errno(&b.h); errno != 0 {
-		err := C.m_error(&b.h)
+// must be called from the executor
+func (r *resources) readOptionFiles(file, group string) bool {
+	cFile := cStringOrNil(file)
+	defer C.free(unsafe.Pointer(cFile))
+
+	cGroup := cStringOrNil(group)
+	defer C.free(unsafe.Pointer(cGroup))
+
+	return C.m_read_option_files(r.h, cFile, cGroup) == 0
+}
+
+// must be called from the executor
+func (r *resources) setAuthOptions(a *AuthOptions) bool {
+	defaultAuth := cStringOrNil(a.DefaultAuth)
+	defer C.free(unsafe.Pointer(defaultAuth))
+
+	pluginDir := cStringOrNil(a.PluginDir)
+	defer C.free(unsafe.Pointer(pluginDir))
+
+	serverPublicKey := cStringOrNil(a.ServerPublicKey)
+	defer C.free(unsafe.Pointer(serverPublicKey))
+
+	return C.m_set_auth_options(r.h, defaultAuth, pluginDir, cBool(a.EnableCleartext),
+		serverPublicKey, cBool(a.GetServerPublicKey)) == 0
+}
+
+// must be called from the executor
+func (r *resources) addInitCommand(command string) bool {
+	cCommand := C.CString(command)
+	defer C.free(unsafe.Pointer(cCommand))
+
+	return C.m_add_init_command(r.h, cCommand) == 0
+}
+
+// must be called from the executor
+func (r *resources) addConnectAttr(key, val string) bool {
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+
+	cVal := C.CString(val)
+	defer C.free(unsafe.Pointer(cVal))
+
+	return C.m_add_connect_attr(r.h, cKey, cVal) == 0
+}
+
+// must be called from the executor
+func (r *resources) lastError() error {
+	if errno := C.m_errno(r.h); errno != 0 {
+		err := C.m_error(r.h)
 		return &MySQLError{uint16(errno), C.GoString(err)}
 	}
 	return nil
 }
 
-func (b *Bridge) query(query string, prepResult int) error {
+// Runs an operation which may have to wait on the network.  start and cont
+// return the socket events to wait for before calling cont again, or 0 once
+// the operation has completed, at which point done is called.  Waiting
+// happens off the executor.
+func (r *resources) call(start func() C.int, cont func(ready C.int) C.int, done func()) {
+	var status C.int
+
+	r.exec.run(func() {
+		if status = start(); status == 0 && done != nil {
+			done()
+		}
+	})
+
+	for status != 0 {
+		ready := r.poll.wait(r.h, status)
+		r.exec.run(func() {
+			if status = cont(ready); status == 0 && done != nil {
+				done()
+			}
+		})
+	}
+}
+
+// Returns a start function for an operation on the streaming result, which
+// first finishes waiting on any row left in progress by FetchRows
+func (r *resources) resumeFetch(op func(ready C.int) C.int) func() C.int {
+	return func() C.int {
+		if status := r.fetchWait; status != 0 {
+			r.fetchWait = 0
+			return status
+		}
+		return op(0)
+	}
+}
+
+// must be called while holding the guard
+func (b *Bridge) query(query string, mode C.int) (err error) {
 	q := C.CString(query)
 	defer C.free(unsafe.Pointer(q))
 
-	if C.m_query(&b.h, q, C.ulong(len(query)), C.int(prepResult)) != 0 {
-		return b.lastError()
+	b.call(func() C.int {
+		return C.m_query_start(b.h, q, C.ulong(len(query)), mode)
+	}, func(ready C.int) C.int {
+		return C.m_query_cont(b.h, ready)
+	}, func() {
+		if b.h.ret != 0 {
+			err = b.lastError()
+		}
+	})
+
+	return err
+}
+
+func (b *Bridge) Close() error {
+	if b.guard.current() == StateClosed {
+		return nil
 	}
+	if err := b.guard.acquire("Close", StateIdle, StateStreaming); err != nil {
+		return err
+	}
+	defer b.guard.release(StateClosed)
+
+	b.cleanup.Stop()
+	b.release()
 
 	return nil
 }
 
-func (b *Bridge) Close() {
-	C.m_close(&b.h)
+func (r *resources) release() {
+	// any open result set must be released before its connection
+	r.flush()
+	r.call(func() C.int {
+		return C.m_close_start(r.h)
+	}, func(ready C.int) C.int {
+		return C.m_close_cont(r.h, ready)
+	}, nil)
+
+	r.poll.close()
+	r.exec.stop()
+	C.free(unsafe.Pointer(r.h))
+	liveBridges.Add(-1)
+
+	if r.infile != 0 {
+		r.infile.Delete()
+	}
+}
+
+// called once a Bridge which was never closed is garbage collected
+func releaseLeakedBridge(r *resources) {
+	ReportLeak("Bridge", r.stack)
+
+	// closing talks to the server, so keep it off the cleanup goroutine
+	go r.release()
 }
 
 func (b *Bridge) IsClosed() bool {
-	return b.h.mysql == nil
+	return b.guard.current() == StateClosed
+}
+
+// Returns what the connection is currently doing
+func (b *Bridge) State() State {
+	return b.guard.current()
 }
 
 func (b *Bridge) Query(query string) error {
-	return b.query(query, 1)
+	if err := b.guard.acquire("Query", StateIdle); err != nil {
+		return err
+	}
+
+	next := StateIdle
+	defer func() { b.guard.release(next) }()
+
+	if err := b.query(query, C.M_RESULT_STREAM); err != nil {
+		return err
+	}
+
+	if b.h.num_fields > 0 {
+		next = StateStreaming
+	}
+	return nil
 }
 
 func (b *Bridge) Execute(query string) error {
-	return b.query(query, 0)
+	if err := b.guard.acquire("Execute", StateIdle); err != nil {
+		return err
+	}
+	defer b.guard.release(StateIdle)
+
+	return b.query(query, C.M_RESULT_NONE)
 }
 
-func (b *Bridge) Flush() {
-	C.m_flush(&b.h)
+// Resets the session state of the connection (COM_RESET_CONNECTION),
+// discarding its variables, temporary tables and open transaction
+func (b *Bridge) Reset() (err error) {
+	if err := b.guard.acquire("Reset", StateIdle); err != nil {
+		return err
+	}
+	defer b.guard.release(StateIdle)
+
+	b.call(func() C.int {
+		return C.m_reset_start(b.h)
+	}, func(ready C.int) C.int {
+		return C.m_reset_cont(b.h, ready)
+	}, func() {
+		if b.h.ret != 0 {
+			err = b.lastError()
+		}
+	})
+
+	return err
+}
+
+// Runs the query and buffers the entire result set client side.  The
+// connection may be used for other queries while the result is open.
+func (b *Bridge) QueryBuffered(query string) (*StoredResult, error) {
+	if err := b.guard.acquire("QueryBuffered", StateIdle); err != nil {
+		return nil, err
+	}
+	defer b.guard.release(StateIdle)
+
+	if err := b.query(query, C.M_RESULT_STORE); err != nil {
+		return nil, err
+	}
+
+	var res *C.MYSQL_RES
+	fields, rowsAffected, insertID := b.Fields(), b.RowsAffected(), b.LastInsertID()
+	b.exec.run(func() {
+		res = C.m_detach_result(b.h)
+	})
+
+	return newStoredResult(res, fields, rowsAffected, insertID), nil
+}
+
+// Discards the rest of the streaming result set, if any
+func (b *Bridge) Flush() error {
+	if err := b.guard.acquire("Flush", StateStreaming, StateIdle); err != nil {
+		return err
+	}
+	defer b.guard.release(StateIdle)
+
+	b.flush()
+	return nil
+}
+
+func (r *resources) flush() {
+	flush := func(ready C.int) C.int {
+		return C.m_flush(r.h, ready)
+	}
+	r.call(r.resumeFetch(flush), flush, nil)
 }
 
 func (b *Bridge) Fields() []MySQLField {
-	nFields := int(b.h.num_fields)
+	return convertFields(b.h.fields, int(b.h.num_fields))
+}
+
+func convertFields(fieldsPtr *C.MYSQL_FIELD, nFields int) []MySQLField {
 	if nFields == 0 {
 		return nil
 	}
 
-	cFields := (*[maxSize]C.MYSQL_FIELD)(unsafe.Pointer(b.h.fields))
+	cFields := (*[maxSize]C.MYSQL_FIELD)(unsafe.Pointer(fieldsPtr))
 
 	fields := make([]MySQLField, nFields)
 	for i := 0; i < nFields; i++ {
@@ -120,18 +502,37 @@ func (b *Bridge) Fields() []MySQLField {
 	return fields
 }
 
-func (b *Bridge) FetchRow() (*[][]byte, error) {
-	mRow := C.m_fetch_row(&b.h)
-	if mRow.has_error != 0 {
-		return nil, b.lastError()
+func (b *Bridge) FetchRow() (row *[][]byte, err error) {
+	if err = b.guard.acquire("FetchRow", StateStreaming); err != nil {
+		return nil, err
+	}
+	defer b.guard.release(StateStreaming)
+
+	var mRow C.M_ROW
+	fetch := func(ready C.int) C.int {
+		mRow = C.m_fetch_row(b.h, ready)
+		return mRow.wait
 	}
 
+	b.call(b.resumeFetch(fetch), fetch, func() {
+		if mRow.has_error != 0 {
+			err = b.lastError()
+			return
+		}
+
+		row = convertRow(mRow, int(b.h.num_fields))
+	})
+
+	return row, err
+}
+
+// copies a row returned by libmysql into go memory
+func convertRow(mRow C.M_ROW, nFields int) *[][]byte {
 	rowPtr := (*[maxSize]*[maxSize]byte)(unsafe.Pointer(mRow.mysql_row))
 	if rowPtr == nil {
-		return nil, nil
+		return nil
 	}
 
-	nFields := int(b.h.num_fields)
 	cLengths := (*[maxSize]uint64)(unsafe.Pointer(mRow.lengths))
 
 	totalLength := uint64(0)
@@ -154,7 +555,41 @@ func (b *Bridge) FetchRow() (*[][]byte, error) {
 		row[i] = arena[start : start+int(fieldLength)]
 	}
 
-	return &row, nil
+	return &row
+}
+
+// Escapes the string for the connection's charset, so that a multibyte
+// character ending in 0x5c (as in gbk, big5 or sjis) can't end the literal,
+// and for its sql_mode, doubling quotes when NO_BACKSLASH_ESCAPES is set
+func (b *Bridge) EscapeString(val string) (out string) {
+	// the query can't be sent on a closed bridge, so the escaping is moot
+	if b.IsClosed() {
+		return EscapeString(val)
+	}
+
+	in := C.CString(val)
+	defer C.free(unsafe.Pointer(in))
+
+	buf := make([]byte, (len(val)*2)+1)
+	cOut := (*C.char)(unsafe.Pointer(&buf[0]))
+
+	b.exec.run(func() {
+		l := C.m_real_escape_string(b.h, cOut, in, C.ulong(len(val)))
+		out = C.GoStringN(cOut, l)
+	})
+	return out
+}
+
+// Returns the character set of the connection
+func (b *Bridge) Charset() (charset string) {
+	if b.IsClosed() {
+		return ""
+	}
+
+	b.exec.run(func() {
+		charset = C.GoString(C.m_charset(b.h))
+	})
+	return charset
 }
 
 func (b *Bridge) RowsAffected() int64 {
@@ -164,3 +599,18 @@ func (b *Bridge) RowsAffected() int64 {
 func (b *Bridge) LastInsertID() int64 {
 	return int64(b.h.insert_id)
 }
+
+// nil for the empty string, must be freed
+func cStringOrNil(val string) *C.char {
+	if val == "" {
+		return nil
+	}
+	return C.CString(val)
+}
+
+func cBool(val bool) C.int {
+	if val {
+		return 1
+	}
+	return 0
+}
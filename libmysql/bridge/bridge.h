@@ -1,5 +1,14 @@
+#include <stdint.h>
 #include <mysql.h>
 
+// result modes for m_query
+#define M_RESULT_NONE	0
+#define M_RESULT_STREAM	1
+#define M_RESULT_STORE	2
+
+// offset reported by m_fetch_rows for NULL fields
+#define M_NULL_OFFSET	((unsigned long)-1)
+
 typedef struct m_handle {
 	MYSQL			*mysql;
 	my_ulonglong	affected_rows;
@@ -7,33 +16,165 @@ typedef struct m_handle {
 	unsigned int	num_fields;
 	MYSQL_FIELD		*fields;
 	MYSQL_RES		*result;
+
+	// a fetched row which did not fit in the last m_fetch_rows buffer
+	MYSQL_ROW		pending_row;
+	unsigned long	*pending_lengths;
+
+	// the result of the last operation completed through a _start/_cont pair
+	int				ret;
+
+	// progress of nonblocking operations
+	int				mode;
+	int				phase;
+	int				query_err;
+	int				fetching;
 } M_HANDLE;
 
 typedef struct m_row {
 	MYSQL_ROW		mysql_row;
 	unsigned long	*lengths;
 	int				has_error;
+	int				wait;
 } M_ROW;
 
 // Initialize the underlying MySQL library
 void m_init();
 int m_escape_string(char *out, char *in, unsigned long length);
+const char *m_default_auth();
+// Escape a string for a literal quoted with ', using the connection's
+// charset and sql_mode.  out must hold length * 2 + 1 bytes.
+int m_real_escape_string(M_HANDLE *conn, char *out, char *in, unsigned long length);
+
+/**
+ * Operations which talk to the server are split into _start and _cont
+ * functions.  Both return the socket events (MYSQL_WAIT_*) to wait for before
+ * calling _cont, or 0 once the operation has completed and conn->ret holds
+ * its result.  Functions which take a ready argument resume an operation in
+ * the same way.
+ *
+ * Unless built with M_ASYNC every operation blocks and completes in _start.
+ */
+
+// Allocate the underlying connection, must be called before setting options or connecting
+int m_open(M_HANDLE *conn);
+
+int m_connect_start(M_HANDLE *conn, const char *host, unsigned int port, const char *user, const char *pass, const char *database);
+int m_connect_cont(M_HANDLE *conn, int ready);
 
-int m_connect(M_HANDLE *conn, const char *host, unsigned int port, const char *user, const char *pass, const char *database);
-void m_close(M_HANDLE *conn);
+// Reset the session state of the connection, as if it had just connected
+int m_reset_start(M_HANDLE *conn);
+int m_reset_cont(M_HANDLE *conn, int ready);
+
+int m_close_start(M_HANDLE *conn);
+int m_close_cont(M_HANDLE *conn, int ready);
 
 int m_errno(M_HANDLE *conn);
 const char *m_error(M_HANDLE *conn);
 
+// Set the charset to connect with, must be called between m_open and m_connect_start
+int m_set_charset(M_HANDLE *conn, const char *charset);
+// The charset of the connection
+const char *m_charset(M_HANDLE *conn);
+
 /**
  * Send a query to the database.
  *
- * query		the SQL query to send to the server
+ * query		the SQL query to send to the server, must stay valid until
+ *				the query completes
  * len			the length of the SQL query
- * prep_result	whether or not to prepare a streaming result set
+ * mode			M_RESULT_NONE to discard any result set, M_RESULT_STREAM to
+ *				prepare a streaming result set, or M_RESULT_STORE to buffer
+ *				the entire result set client side
+ */
+int m_query_start(M_HANDLE *conn, const char *query, unsigned long len, int mode);
+int m_query_cont(M_HANDLE *conn, int ready);
+
+// Discard the rest of the current result set, returns the events to wait for
+int m_flush(M_HANDLE *conn, int ready);
+
+// Fetch the next row of the streaming result, row.wait is set when the
+// caller must wait and call again
+M_ROW m_fetch_row(M_HANDLE *conn, int ready);
+
+/**
+ * Fetch up to max_rows rows from a streaming result set in a single call,
+ * copying their fields into a contiguous buffer.
+ *
+ * buf			receives the field data
+ * buf_len		the size of buf
+ * offsets		receives the start of each field within buf, or M_NULL_OFFSET
+ *				for NULL fields, must hold max_rows * num_fields entries
+ * lengths		receives the length of each field, same size as offsets
+ * needed		set to the buffer size required to make progress when the
+ *				next row does not fit in buf at all
+ * wait			set to the events to wait for when the next row is not yet
+ *				available, rows fetched before that point are still returned
+ *
+ * Returns the number of rows fetched, 0 at the end of the result set (or
+ * when needed or wait is set), and -1 on error.
+ */
+int m_fetch_rows(M_HANDLE *conn, char *buf, unsigned long buf_len, unsigned long *offsets, unsigned long *lengths, int max_rows, unsigned long *needed, int ready, int *wait);
+
+/**
+ * Take ownership of a result set buffered by m_query_start, leaving the
+ * connection free for other queries.  The result must be released with
+ * mysql_free_result.
+ */
+MYSQL_RES *m_detach_result(M_HANDLE *conn);
+
+M_ROW m_fetch_stored_row(MYSQL_RES *result);
+
+/**
+ * Enable LOAD DATA LOCAL INFILE on the connection, must be called between
+ * m_open and m_connect_start.
+ *
+ * userdata		opaque handle passed back to the go infile callbacks
+ */
+int m_enable_local_infile(M_HANDLE *conn, uintptr_t userdata);
+
+/**
+ * Read connection defaults from option files, must be called between m_open
+ * and m_connect_start.
+ *
+ * file		the option file to read instead of the default ones, or NULL
+ * group	the group to read besides [client], or NULL
+ */
+int m_read_option_files(M_HANDLE *conn, const char *file, const char *group);
+
+/**
+ * Configure authentication, must be called between m_open and
+ * m_connect_start.
+ *
+ * default_auth			the plugin to authenticate with first, or NULL
+ * plugin_dir			where client plugins are loaded from, or NULL
+ * cleartext			allow mysql_clear_password to send the password unencrypted
+ * server_public_key	path to the server's RSA public key in PEM format, or NULL
+ * get_server_public_key	request the RSA public key from the server
+ */
+int m_set_auth_options(M_HANDLE *conn, const char *default_auth, const char *plugin_dir,
+		int cleartext, const char *server_public_key, int get_server_public_key);
+
+// Fail the connect if TLS can't be used, must be called between m_open and m_connect_start
+int m_require_tls(M_HANDLE *conn);
+
+/**
+ * Add a statement run by the library once connected, must be called between
+ * m_open and m_connect_start.  Commands run in the order they were added.
+ */
+int m_add_init_command(M_HANDLE *conn, const char *command);
+
+/**
+ * Add a connection attribute sent to the server, must be called between
+ * m_open and m_connect_start.
  */
-int m_query(M_HANDLE *conn, const char *query, unsigned long len, int prep_result);
+int m_add_connect_attr(M_HANDLE *conn, const char *key, const char *value);
 
-void m_flush(M_HANDLE *conn);
+#ifdef M_ASYNC
+// The socket and timeout to wait on for nonblocking operations
+int m_socket(M_HANDLE *conn);
+unsigned int m_timeout_ms(M_HANDLE *conn);
 
-M_ROW m_fetch_row(M_HANDLE *conn);
+// The subset of the MYSQL_WAIT_* events in status which fd is ready for now
+int m_ready(int fd, int status);
+#endif
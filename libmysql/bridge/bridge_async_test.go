@@ -0,0 +1,159 @@
+//go:build libmysql_async
+
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/carlsverre/go-libmysql/libmysql/testserver"
+	. "gopkg.in/check.v1"
+)
+
+// runs the nonblocking backend against the in-process test server, so that
+// every call goes through the _start/_cont loop and the netpoller
+type AsyncSuite struct {
+	srv *testserver.Server
+}
+
+var _ = Suite(&AsyncSuite{})
+
+func (s *AsyncSuite) SetUpTest(c *C) {
+	var err error
+	s.srv, err = testserver.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+}
+
+func (s *AsyncSuite) TearDownTest(c *C) {
+	s.srv.Close()
+}
+
+func (s *AsyncSuite) connect(c *C) *Bridge {
+	addr := s.srv.Addr().(*net.TCPAddr)
+	b, err := NewBridge(addr.IP.String(), addr.Port, "root", "", "", nil)
+	c.Assert(err, IsNil)
+	return b
+}
+
+func (s *AsyncSuite) TestQuery(c *C) {
+	var rows [][]interface{}
+	for i := 0; i < 1000; i++ {
+		rows = append(rows, []interface{}{i, fmt.Sprintf("row %d", i)})
+	}
+	s.srv.Handle(`^SELECT id, name FROM x$`, testserver.Rows([]string{"id", "name"}, rows...))
+	s.srv.Handle(`^INSERT`, testserver.Exec(3, 7))
+
+	b := s.connect(c)
+
+	c.Assert(b.Query("SELECT id, name FROM x"), IsNil)
+	c.Assert(b.Fields(), HasLen, 2)
+	c.Assert(b.Fields()[1].Name, Equals, "name")
+
+	// one row at a time, then the rest in batches
+	for i := 0; i < 10; i++ {
+		row, err := b.FetchRow()
+		c.Assert(err, IsNil)
+		c.Assert(string((*row)[1]), Equals, fmt.Sprintf("row %d", i))
+	}
+
+	batch := NewRowBatch(64)
+	count := 10
+	for {
+		n, err := b.FetchRows(batch)
+		c.Assert(err, IsNil)
+		if n == 0 {
+			break
+		}
+		for i := 0; i < n; i++ {
+			c.Assert(string(batch.Field(i, 1)), Equals, fmt.Sprintf("row %d", count))
+			count++
+		}
+	}
+	c.Assert(count, Equals, 1000)
+	c.Assert(b.State(), Equals, StateIdle)
+
+	c.Assert(b.Execute("INSERT INTO x VALUES (1), (2), (3)"), IsNil)
+	c.Assert(b.RowsAffected(), Equals, int64(3))
+	c.Assert(b.LastInsertID(), Equals, int64(7))
+
+	c.Assert(b.Close(), IsNil)
+	c.Assert(b.IsClosed(), Equals, true)
+}
+
+func (s *AsyncSuite) TestFlush(c *C) {
+	var rows [][]interface{}
+	for i := 0; i < 10000; i++ {
+		rows = append(rows, []interface{}{i})
+	}
+	s.srv.Handle(`^SELECT id FROM x$`, testserver.Rows([]string{"id"}, rows...))
+	s.srv.Handle(`^SELECT 1$`, testserver.Rows([]string{"1"}, []interface{}{1}))
+
+	b := s.connect(c)
+	defer b.Close()
+
+	c.Assert(b.Query("SELECT id FROM x"), IsNil)
+	_, err := b.FetchRow()
+	c.Assert(err, IsNil)
+	c.Assert(b.Flush(), IsNil)
+
+	c.Assert(b.Query("SELECT 1"), IsNil)
+	row, err := b.FetchRow()
+	c.Assert(err, IsNil)
+	c.Assert(string((*row)[0]), Equals, "1")
+}
+
+func (s *AsyncSuite) TestError(c *C) {
+	s.srv.Handle(`^SELECT \* FROM missing$`, testserver.Fail(1146, "Table 'missing' doesn't exist"))
+	s.srv.Handle(`^SELECT \* FROM gone$`, testserver.Disconnect())
+
+	b := s.connect(c)
+	defer b.Close()
+
+	var myErr *MySQLError
+	c.Assert(errors.As(b.Query("SELECT * FROM missing"), &myErr), Equals, true)
+	c.Assert(myErr.Errno, Equals, uint16(1146))
+
+	// the dropped connection is noticed by the netpoller wait
+	c.Assert(errors.As(b.Query("SELECT * FROM gone"), &myErr), Equals, true)
+	c.Assert(myErr.Errno == 2013 || myErr.Errno == 2006, Equals, true, Commentf("%v", myErr))
+}
+
+func (s *AsyncSuite) TestConcurrent(c *C) {
+	s.srv.Handle(`^SELECT SLEEP`, func(q *testserver.Query) (*testserver.Result, error) {
+		time.Sleep(100 * time.Millisecond)
+		return &testserver.Result{Columns: []string{"slept"}, Rows: [][]interface{}{{0}}}, nil
+	})
+
+	// the goroutines wait in the netpoller, so the queries overlap
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			b := s.connect(c)
+			defer b.Close()
+
+			c.Check(b.Query("SELECT SLEEP(0.1)"), IsNil)
+			_, err := b.FetchRow()
+			c.Check(err, IsNil)
+		}()
+	}
+	wg.Wait()
+	c.Assert(time.Since(start) < time.Second, Equals, true)
+}
+
+func (s *AsyncSuite) TestConnectRefused(c *C) {
+	addr := s.srv.Addr().(*net.TCPAddr)
+	s.srv.Close()
+
+	_, err := NewBridge(addr.IP.String(), addr.Port, "root", "", "", nil)
+
+	var myErr *MySQLError
+	c.Assert(errors.As(err, &myErr), Equals, true)
+	c.Assert(myErr.Errno, Equals, uint16(2003))
+}
@@ -1,9 +1,21 @@
 package bridge
 
 import (
+	"errors"
 	"fmt"
 )
 
+var (
+	errOutOfMemory = errors.New("Failed to allocate a MySQL connection handle")
+	errLocalInfile = errors.New("Failed to enable LOAD DATA LOCAL INFILE")
+	errConnectAttr = errors.New("Failed to add a connection attribute")
+	errCharset     = errors.New("Failed to set the connection charset")
+	errInitCommand = errors.New("Failed to add an init command")
+	errAuthOptions = errors.New("Failed to set authentication options")
+	errRequireTLS  = errors.New("Failed to require TLS")
+	errOptionFile  = errors.New("Failed to read option files")
+)
+
 type MySQLError struct {
 	Errno   uint16
 	Message string
@@ -0,0 +1,56 @@
+//go:build !libmysql_async
+
+package bridge
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import "runtime"
+
+// executor runs every libmysql call for a single Bridge on a dedicated OS
+// thread.  The client library keeps per-thread state which must be set up
+// with mysql_thread_init and released with mysql_thread_end on the thread
+// which uses the connection.
+type executor struct {
+	cmds chan func()
+	done chan struct{}
+}
+
+func newExecutor() *executor {
+	e := &executor{
+		cmds: make(chan func()),
+		done: make(chan struct{}),
+	}
+	go e.loop()
+	return e
+}
+
+func (e *executor) loop() {
+	// never unlocked, so the thread exits along with the goroutine
+	runtime.LockOSThread()
+	C.mysql_thread_init()
+
+	for cmd := range e.cmds {
+		cmd()
+	}
+
+	C.mysql_thread_end()
+	close(e.done)
+}
+
+// Runs f on the executor's thread and waits for it to complete
+func (e *executor) run(f func()) {
+	done := make(chan struct{})
+	e.cmds <- func() {
+		defer close(done)
+		f()
+	}
+	<-done
+}
+
+// Releases the thread, no commands may be run afterwards
+func (e *executor) stop() {
+	close(e.cmds)
+	<-e.done
+}
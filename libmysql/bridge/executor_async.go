@@ -0,0 +1,18 @@
+//go:build libmysql_async
+
+package bridge
+
+// With the nonblocking backend no libmysql call ever blocks, and MariaDB
+// Connector/C keeps no per-thread state, so calls run directly on the calling
+// goroutine rather than on a dedicated thread.
+type executor struct{}
+
+func newExecutor() *executor {
+	return &executor{}
+}
+
+func (e *executor) run(f func()) {
+	f()
+}
+
+func (e *executor) stop() {}
@@ -0,0 +1,84 @@
+package bridge
+
+/*
+#include <stdint.h>
+*/
+import "C"
+import (
+	"fmt"
+	"io"
+	"runtime/cgo"
+	"unsafe"
+)
+
+const (
+	// CR_UNKNOWN_ERROR is reported to the client library when a local infile can not be read
+	crUnknownError = 2000
+)
+
+// InfileOpener opens the named file for a LOAD DATA LOCAL INFILE statement
+type InfileOpener func(name string) (io.Reader, error)
+
+// state of a single LOAD DATA LOCAL INFILE transfer
+type infile struct {
+	name   string
+	reader io.Reader
+	err    error
+}
+
+//export goInfileInit
+func goInfileInit(filename *C.char, userdata C.uintptr_t, handle *C.uintptr_t) C.int {
+	open := cgo.Handle(userdata).Value().(InfileOpener)
+	f := &infile{name: C.GoString(filename)}
+	f.reader, f.err = open(f.name)
+
+	*handle = C.uintptr_t(cgo.NewHandle(f))
+	if f.err != nil {
+		return 1
+	}
+	return 0
+}
+
+//export goInfileRead
+func goInfileRead(handle C.uintptr_t, buf *C.char, bufLen C.uint) C.int {
+	f := cgo.Handle(handle).Value().(*infile)
+	out := unsafe.Slice((*byte)(unsafe.Pointer(buf)), int(bufLen))
+
+	for {
+		n, err := f.reader.Read(out)
+		if n > 0 {
+			return C.int(n)
+		}
+		if err == io.EOF {
+			return 0
+		} else if err != nil {
+			f.err = err
+			return -1
+		}
+	}
+}
+
+//export goInfileEnd
+func goInfileEnd(handle C.uintptr_t) {
+	h := cgo.Handle(handle)
+	f := h.Value().(*infile)
+	if closer, ok := f.reader.(io.Closer); ok {
+		closer.Close()
+	}
+	h.Delete()
+}
+
+//export goInfileError
+func goInfileError(handle C.uintptr_t, msg *C.char, msgLen C.uint) C.int {
+	f := cgo.Handle(handle).Value().(*infile)
+	if msgLen == 0 {
+		return crUnknownError
+	}
+
+	text := fmt.Sprintf("Failed to read local infile %s: %v", f.name, f.err)
+	out := unsafe.Slice((*byte)(unsafe.Pointer(msg)), int(msgLen))
+	n := copy(out[:len(out)-1], text)
+	out[n] = 0
+
+	return crUnknownError
+}
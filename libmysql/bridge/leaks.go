@@ -0,0 +1,64 @@
+package bridge
+
+import (
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// LeakReporter is called with the kind of object and the stack which
+// allocated it whenever an object is garbage collected without being closed
+type LeakReporter func(kind, stack string)
+
+var (
+	leakReporter atomic.Pointer[LeakReporter]
+
+	liveBridges       atomic.Int64
+	liveStoredResults atomic.Int64
+	leakedObjects     atomic.Int64
+)
+
+// Counters holds the number of C resources currently owned by the package
+type Counters struct {
+	// open MySQL connection handles
+	Bridges int64
+	// buffered result sets which have not been freed
+	StoredResults int64
+	// objects which were garbage collected without being closed
+	Leaked int64
+}
+
+// Returns the current resource counters
+func GetCounters() Counters {
+	return Counters{
+		Bridges:       liveBridges.Load(),
+		StoredResults: liveStoredResults.Load(),
+		Leaked:        leakedObjects.Load(),
+	}
+}
+
+// Enables leak reporting, or disables it when r is nil.  While enabled every
+// new object records the stack which allocated it, which is expensive.
+func SetLeakReporter(r LeakReporter) {
+	if r == nil {
+		leakReporter.Store(nil)
+	} else {
+		leakReporter.Store(&r)
+	}
+}
+
+// Returns the stack to remember for a new object, or "" when leak reporting
+// is disabled
+func AllocationStack() string {
+	if leakReporter.Load() == nil {
+		return ""
+	}
+	return string(debug.Stack())
+}
+
+// Records that an object was garbage collected without being closed
+func ReportLeak(kind, stack string) {
+	leakedObjects.Add(1)
+	if r := leakReporter.Load(); r != nil {
+		(*r)(kind, stack)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !libmysql_async
+
+package bridge
+
+/*
+#include "bridge.h"
+*/
+import "C"
+
+// the blocking client library never asks to wait on the socket
+type poller struct{}
+
+func (p *poller) wait(h *C.M_HANDLE, status C.int) C.int {
+	return status
+}
+
+func (p *poller) close() {}
@@ -0,0 +1,116 @@
+//go:build libmysql_async
+
+// The libmysql_async build tag switches the bridge to MariaDB Connector/C's
+// nonblocking _start/_cont API.  Rather than blocking an OS thread inside
+// libmysql, every call returns as soon as it would block and the goroutine
+// waits for the socket in Go's netpoller, so thousands of slow queries no
+// longer need thousands of threads.
+//
+//	go build -tags libmysql_async
+//
+// The Bridge API is the same with either backend.
+
+package bridge
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// the socket events libmysql waits for
+const (
+	waitRead    C.int = C.MYSQL_WAIT_READ
+	waitWrite   C.int = C.MYSQL_WAIT_WRITE
+	waitExcept  C.int = C.MYSQL_WAIT_EXCEPT
+	waitTimeout C.int = C.MYSQL_WAIT_TIMEOUT
+)
+
+// waits on the connection's socket using the netpoller
+type poller struct {
+	file *os.File
+	conn syscall.RawConn
+}
+
+// Blocks the goroutine until the socket is ready for one of the events in
+// status, and returns the events which occurred
+func (p *poller) wait(h *C.M_HANDLE, status C.int) C.int {
+	if p.file == nil {
+		if err := p.open(int(C.m_socket(h))); err != nil {
+			return waitExcept
+		}
+	}
+
+	var timeout time.Duration
+	if status&waitTimeout != 0 {
+		timeout = time.Duration(C.m_timeout_ms(h)) * time.Millisecond
+	}
+	return p.waitFor(status, timeout)
+}
+
+// Waits for the events in status, giving up after timeout unless it is zero
+func (p *poller) waitFor(status C.int, timeout time.Duration) C.int {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	p.file.SetDeadline(deadline)
+
+	var ready C.int
+	check := func(fd uintptr) bool {
+		ready = C.m_ready(C.int(fd), status)
+		return ready != 0
+	}
+
+	var err error
+	switch {
+	case status&waitWrite != 0:
+		err = p.conn.Write(check)
+	case status&waitRead != 0:
+		err = p.conn.Read(check)
+	default:
+		time.Sleep(time.Until(deadline))
+		return waitTimeout
+	}
+
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return waitTimeout
+	} else if err != nil {
+		return waitExcept
+	}
+	return ready
+}
+
+// Registers a duplicate of the socket with the netpoller, so that closing it
+// leaves libmysql's socket alone
+func (p *poller) open(socket int) error {
+	fd, err := syscall.Dup(socket)
+	if err != nil {
+		return err
+	}
+
+	if err = syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	p.file = os.NewFile(uintptr(fd), "mysql")
+	if p.conn, err = p.file.SyscallConn(); err != nil {
+		p.close()
+		return err
+	}
+
+	return nil
+}
+
+func (p *poller) close() {
+	if p.file != nil {
+		p.file.Close()
+		p.file = nil
+	}
+}
@@ -0,0 +1,59 @@
+//go:build libmysql_async
+
+package bridge
+
+import (
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type PollerSuite struct {
+	p    poller
+	peer int
+}
+
+var _ = Suite(&PollerSuite{})
+
+func (s *PollerSuite) SetUpTest(c *C) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	c.Assert(err, IsNil)
+
+	s.p = poller{}
+	c.Assert(s.p.open(fds[0]), IsNil)
+	syscall.Close(fds[0])
+	s.peer = fds[1]
+}
+
+func (s *PollerSuite) TearDownTest(c *C) {
+	s.p.close()
+	syscall.Close(s.peer)
+}
+
+func (s *PollerSuite) TestReady(c *C) {
+	c.Assert(s.p.waitFor(waitWrite, time.Second), Equals, waitWrite)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		syscall.Write(s.peer, []byte("x"))
+	}()
+	c.Assert(s.p.waitFor(waitRead|waitTimeout, time.Second), Equals, waitRead)
+}
+
+func (s *PollerSuite) TestTimeout(c *C) {
+	start := time.Now()
+	c.Assert(s.p.waitFor(waitRead|waitTimeout, 20*time.Millisecond), Equals, waitTimeout)
+	c.Assert(time.Since(start) >= 20*time.Millisecond, Equals, true)
+
+	// libmysql may only ask to wait for the timeout
+	c.Assert(s.p.waitFor(waitTimeout, time.Millisecond), Equals, waitTimeout)
+}
+
+func (s *PollerSuite) TestClosed(c *C) {
+	syscall.Close(s.peer)
+	s.peer = -1
+
+	// a closed peer wakes the reader, which then sees the end of the stream
+	c.Assert(s.p.waitFor(waitRead, time.Second)&waitRead, Equals, waitRead)
+}
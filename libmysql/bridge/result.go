@@ -0,0 +1,88 @@
+package bridge
+
+/*
+#include <stdlib.h>
+#include "bridge.h"
+*/
+import "C"
+import "runtime"
+
+// StoredResult is a result set which has been buffered client side with
+// mysql_store_result.  It is independent of the Bridge which created it.
+type StoredResult struct {
+	res          *C.MYSQL_RES
+	fields       []MySQLField
+	rowsAffected int64
+	insertID     int64
+	cleanup      runtime.Cleanup
+}
+
+// takes ownership of res, which is freed if the StoredResult is garbage
+// collected without being freed
+func newStoredResult(res *C.MYSQL_RES, fields []MySQLField, rowsAffected, insertID int64) *StoredResult {
+	r := &StoredResult{
+		res:          res,
+		fields:       fields,
+		rowsAffected: rowsAffected,
+		insertID:     insertID,
+	}
+
+	if res != nil {
+		liveStoredResults.Add(1)
+		stack := AllocationStack()
+		r.cleanup = runtime.AddCleanup(r, func(res *C.MYSQL_RES) {
+			ReportLeak("StoredResult", stack)
+			freeStoredResult(res)
+		}, res)
+	}
+
+	return r
+}
+
+func freeStoredResult(res *C.MYSQL_RES) {
+	C.mysql_free_result(res)
+	liveStoredResults.Add(-1)
+}
+
+func (r *StoredResult) Fields() []MySQLField {
+	return r.fields
+}
+
+// Returns the number of rows in the result set
+func (r *StoredResult) NumRows() int64 {
+	if r.res == nil {
+		return 0
+	}
+	return int64(C.mysql_num_rows(r.res))
+}
+
+// Moves the cursor such that the next call to FetchRow returns the row at
+// the provided offset
+func (r *StoredResult) DataSeek(row int64) {
+	if r.res != nil {
+		C.mysql_data_seek(r.res, C.my_ulonglong(row))
+	}
+}
+
+func (r *StoredResult) FetchRow() *[][]byte {
+	if r.res == nil {
+		return nil
+	}
+	return convertRow(C.m_fetch_stored_row(r.res), len(r.fields))
+}
+
+func (r *StoredResult) RowsAffected() int64 {
+	return r.rowsAffected
+}
+
+func (r *StoredResult) LastInsertID() int64 {
+	return r.insertID
+}
+
+func (r *StoredResult) Free() {
+	if r.res != nil {
+		r.cleanup.Stop()
+		freeStoredResult(r.res)
+		r.res = nil
+	}
+}
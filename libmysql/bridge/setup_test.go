@@ -0,0 +1,10 @@
+package bridge
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// hook into gocheck
+func Test(t *testing.T) { TestingT(t) }
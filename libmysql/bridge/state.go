@@ -0,0 +1,116 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// State describes what a Bridge is currently doing
+type State int
+
+const (
+	// ready for a new command
+	StateIdle State = iota
+	// a command is running in libmysql
+	StateQuerying
+	// a streaming result set is open and must be read or flushed
+	StateStreaming
+	// the connection has been closed
+	StateClosed
+)
+
+var (
+	ErrBusy              = errors.New("Connection is in use by another operation")
+	ErrCommandsOutOfSync = errors.New("Commands out of sync: close the open streaming result before issuing another command, or use buffered mode")
+	ErrClosed            = errors.New("Connection is closed")
+)
+
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateQuerying:
+		return "querying"
+	case StateStreaming:
+		return "streaming"
+	case StateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// StateError is returned when an operation is not allowed in the current
+// state of the connection
+type StateError struct {
+	Op    string
+	State State
+
+	// stack of the operation which currently holds the connection, only
+	// recorded when built with the libmysql_debug tag
+	Holder string
+}
+
+func (err *StateError) Error() string {
+	msg := fmt.Sprintf("Cannot %s: connection is %s", err.Op, err.State)
+	if err.Holder != "" {
+		msg += "\nheld by:\n" + err.Holder
+	}
+	return msg
+}
+
+// allows errors.Is(err, ErrCommandsOutOfSync) and friends
+func (err *StateError) Unwrap() error {
+	switch err.State {
+	case StateQuerying:
+		return ErrBusy
+	case StateStreaming:
+		return ErrCommandsOutOfSync
+	case StateClosed:
+		return ErrClosed
+	}
+	return nil
+}
+
+// guard tracks the state of a connection and rejects illegal transitions
+type guard struct {
+	mu     sync.Mutex
+	state  State
+	holder string
+}
+
+// Marks the connection as busy running op, which is only allowed from one
+// of the provided states
+func (g *guard) acquire(op string, from ...State) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, s := range from {
+		if g.state == s {
+			g.state = StateQuerying
+			if recordHolders && s != StateStreaming {
+				g.holder = captureStack()
+			}
+			return nil
+		}
+	}
+
+	return &StateError{Op: op, State: g.state, Holder: g.holder}
+}
+
+// Finishes the operation started by acquire
+func (g *guard) release(to State) {
+	g.mu.Lock()
+	g.state = to
+	if to != StateStreaming {
+		// streaming results keep pointing at the query which opened them
+		g.holder = ""
+	}
+	g.mu.Unlock()
+}
+
+func (g *guard) current() State {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.state
+}
@@ -0,0 +1,11 @@
+//go:build libmysql_debug
+
+package bridge
+
+import "runtime/debug"
+
+const recordHolders = true
+
+func captureStack() string {
+	return string(debug.Stack())
+}
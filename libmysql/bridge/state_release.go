@@ -0,0 +1,9 @@
+//go:build !libmysql_debug
+
+package bridge
+
+const recordHolders = false
+
+func captureStack() string {
+	return ""
+}
@@ -0,0 +1,54 @@
+package bridge
+
+import (
+	"errors"
+
+	. "gopkg.in/check.v1"
+)
+
+type StateSuite struct{}
+
+var _ = Suite(&StateSuite{})
+
+func (s *StateSuite) TestTransitions(c *C) {
+	var g guard
+	c.Assert(g.current(), Equals, StateIdle)
+
+	// a query which opens a streaming result
+	c.Assert(g.acquire("Query", StateIdle), IsNil)
+	c.Assert(g.current(), Equals, StateQuerying)
+
+	err := g.acquire("Execute", StateIdle)
+	c.Assert(errors.Is(err, ErrBusy), Equals, true)
+
+	g.release(StateStreaming)
+	err = g.acquire("Execute", StateIdle)
+	c.Assert(errors.Is(err, ErrCommandsOutOfSync), Equals, true)
+	c.Assert(err.(*StateError).Op, Equals, "Execute")
+
+	c.Assert(g.acquire("FetchRow", StateStreaming), IsNil)
+	g.release(StateStreaming)
+
+	c.Assert(g.acquire("Flush", StateStreaming, StateIdle), IsNil)
+	g.release(StateIdle)
+
+	c.Assert(g.acquire("Close", StateIdle, StateStreaming), IsNil)
+	g.release(StateClosed)
+
+	err = g.acquire("Query", StateIdle)
+	c.Assert(errors.Is(err, ErrClosed), Equals, true)
+	c.Assert(err, ErrorMatches, "Cannot Query: connection is closed")
+}
+
+func (s *StateSuite) TestHolder(c *C) {
+	var g guard
+	c.Assert(g.acquire("Query", StateIdle), IsNil)
+	g.release(StateStreaming)
+
+	err := g.acquire("Execute", StateIdle).(*StateError)
+	if recordHolders {
+		c.Assert(err.Holder, Matches, "(?s).*TestHolder.*")
+	} else {
+		c.Assert(err.Holder, Equals, "")
+	}
+}
@@ -0,0 +1,94 @@
+package libmysql
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+)
+
+var (
+	errSeekOutOfRange = errors.New("Seek offset is outside of the result set")
+)
+
+// BufferedRows is a result set which has been read entirely into client
+// memory.  Unlike the default streaming results, the connection may be used
+// for other queries while it is open.
+type BufferedRows struct {
+	c       *Conn
+	res     StoredResult
+	columns []string
+	closed  bool
+
+	tracker *rowsTracker
+}
+
+func newBufferedRows(c *Conn, res StoredResult, tracker *rowsTracker) *BufferedRows {
+	fields := res.Fields()
+
+	r := &BufferedRows{c: c, res: res, tracker: tracker}
+	r.columns = make([]string, len(fields))
+	for i, f := range fields {
+		r.columns[i] = f.Name
+	}
+
+	return r
+}
+
+func (r *BufferedRows) Close() error {
+	if !r.closed {
+		r.closed = true
+		r.tracker.done(nil)
+		r.res.Free()
+	}
+	return nil
+}
+
+func (r *BufferedRows) Columns() []string {
+	return r.columns
+}
+
+// RowCount returns the total number of rows in the result set
+func (r *BufferedRows) RowCount() int64 {
+	if r.closed {
+		return 0
+	}
+	return r.res.NumRows()
+}
+
+// SeekRow positions the result such that the next call to Next returns the row
+// at the provided zero based offset
+func (r *BufferedRows) SeekRow(row int64) error {
+	if r.closed {
+		return rowsClosed
+	}
+	if row < 0 || row > r.res.NumRows() {
+		return errSeekOutOfRange
+	}
+
+	r.res.DataSeek(row)
+	return nil
+}
+
+func (r *BufferedRows) Next(dest []driver.Value) error {
+	if r.closed {
+		return rowsClosed
+	}
+
+	row := r.res.FetchRow()
+	if row == nil {
+		r.tracker.done(nil)
+		return io.EOF
+	}
+
+	for i, field := range *row {
+		if field == nil {
+			dest[i] = nil
+		} else {
+			dest[i] = field
+		}
+	}
+
+	r.c.observeRow(dest)
+	r.tracker.row()
+	return nil
+}
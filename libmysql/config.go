@@ -2,10 +2,130 @@ package libmysql
 
 import "C"
 
-type config struct {
-	host     string
-	port     int
-	user     string
-	pass     string
-	database string
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Config holds the settings connections are opened with, see ParseDSN
+type Config struct {
+	Host string
+	Port int
+	// the servers to connect to when there are several, Host and Port are
+	// the first of them.  A new connection tries them in the order picked by
+	// HostStrategy until one can be reached.
+	Hosts        []Address
+	HostStrategy HostStrategy
+	// how long a host which couldn't be reached is tried last, doubling with
+	// every consecutive failure
+	HostBackoff time.Duration
+
+	User     string
+	Pass     string
+	Database string
+
+	// allow LOAD DATA LOCAL INFILE from registered readers and files
+	LocalInfile bool
+
+	// buffer entire result sets client side rather than streaming them
+	Buffered bool
+
+	// number of rows fetched per call into libmysql, 1 disables batching
+	RowBatchSize int
+
+	// the character set and collation of the connection, the collation
+	// implies its charset when Charset is empty.  Connecting fails if the
+	// server does not support them.
+	Charset   string
+	Collation string
+
+	// have libmysqlclient read defaults for the connection from an option
+	// file, and the group within it besides [client], see ReadOptionFile
+	// to load one into a Config instead
+	OptionFile  string
+	OptionGroup string
+
+	// the authentication plugin to attempt first, such as
+	// caching_sha2_password, and where client plugins are loaded from
+	DefaultAuth string
+	PluginDir   string
+	// allow mysql_clear_password, which sends the password unencrypted, as
+	// needed by PAM and LDAP authentication
+	AllowCleartextPasswords bool
+	// the path to the server's RSA public key in PEM format, or whether to
+	// request it from the server, for caching_sha2_password without TLS
+	ServerPublicKey    string
+	GetServerPublicKey bool
+
+	// refuse to connect without TLS
+	RequireTLS bool
+
+	// provides the user and password of every new connection in place of
+	// User and Pass, for credentials which change over time
+	Credentials CredentialProvider
+
+	// statements run on every new connection, before anything else, and
+	// again after its session is reset
+	InitCommands []string
+
+	// session variables set on every new connection, and again after its
+	// session is reset, such as time_zone or sql_mode.  In a DSN any lower
+	// case parameter which isn't the driver's own is a session variable, and
+	// its value is percent-decoded except for +, so time_zone=+00:00 can be
+	// given as is.
+	Params map[string]string
+
+	// reset the session state (COM_RESET_CONNECTION) whenever database/sql
+	// reuses a connection, then run InitCommands, set Params and run the
+	// connector's OnConnect functions again
+	ResetSession bool
+
+	// connection attributes sent to the server, visible in
+	// performance_schema.session_connect_attrs.  They are added to the
+	// driver's defaults (see defaultConnectAttrs), overriding any with the
+	// same key.  Well known keys include program_name, service, version
+	// and hostname.
+	ConnectAttrs map[string]string
+}
+
+// the attributes identifying the driver sent with every connection
+func defaultConnectAttrs() map[string]string {
+	return map[string]string{
+		"program_name": filepath.Base(os.Args[0]),
+		"driver_name":  "go-libmysql",
+		"go_version":   runtime.Version(),
+	}
+}
+
+// the charset to connect with, which may be implied by the collation
+func (cfg *Config) charset() string {
+	if cfg.Charset == "" && cfg.Collation != "" {
+		charset, _, _ := strings.Cut(cfg.Collation, "_")
+		return charset
+	}
+	return cfg.Charset
+}
+
+// The charset and collation are sent in SET NAMES, so must be plain names
+func (cfg *Config) checkCharset() error {
+	if cfg.Charset != "" && !rName.MatchString(cfg.Charset) {
+		return fmt.Errorf("Invalid charset %q", cfg.Charset)
+	}
+	if cfg.Collation != "" && !rName.MatchString(cfg.Collation) {
+		return fmt.Errorf("Invalid collation %q", cfg.Collation)
+	}
+	return nil
+}
+
+// the connection attributes to send, the defaults and the configured ones
+func (cfg *Config) connectAttrs() map[string]string {
+	attrs := defaultConnectAttrs()
+	for key, val := range cfg.ConnectAttrs {
+		attrs[key] = val
+	}
+	return attrs
 }
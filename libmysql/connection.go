@@ -1,29 +1,89 @@
 package libmysql
 
 import (
+	"context"
 	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/carlsverre/go-libmysql/libmysql/bridge"
 	"github.com/carlsverre/go-libmysql/libmysql/escape"
 )
 
+const (
+	defaultRowBatchSize = 128
+)
+
+var (
+	// returned (wrapped in a *bridge.StateError) when a command is issued
+	// while a streaming result is still being read from the same connection
+	ErrCommandsOutOfSync = bridge.ErrCommandsOutOfSync
+
+	// returned (wrapped in a *bridge.StateError) when a connection is used
+	// after it has been closed
+	ErrConnClosed = bridge.ErrClosed
+
+	errBufferingUnsupported = errors.New("Buffered queries are not supported by this backend")
+	errNamedArgs            = errors.New("Named arguments are not supported")
+
+	// session variable values which are sent as numbers
+	rNumber = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+	// errors after which the connection can't be used again
+	lostErrnos = map[uint16]bool{
+		bridge.ER_SERVER_SHUTDOWN: true,
+		2006:                      true, // CR_SERVER_GONE_ERROR
+		2013:                      true, // CR_SERVER_LOST
+	}
+)
+
 // implements the sql/driver Conn interface
 type Conn struct {
-	cfg    *config
-	bridge *bridge.Bridge
-}
+	cfg     *Config
+	backend Backend
 
-func NewConn(dsn string) (*Conn, error) {
-	var err error
+	// the server the connection was opened to, out of its hosts
+	hosts *hostPool
+	addr  Address
+
+	// reused by every streaming result on this connection
+	batch *bridge.RowBatch
 
-	c := new(Conn)
+	// set once the connection to the server is lost
+	lost bool
+
+	hooks
+	metrics *metrics
+}
 
-	c.cfg, err = parseDSN(dsn)
+func NewConn(dsn string) (*Conn, error) {
+	cfg, err := ParseDSN(dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = c.open(); err != nil {
+	return newConn(context.Background(), cfg, nil, newBridgeBackend(), hooks{})
+}
+
+// hosts is shared by the connections of a Connector, nil for a connection of
+// its own
+func newConn(ctx context.Context, cfg *Config, hosts *hostPool, backend Backend, h hooks) (*Conn, error) {
+	if hosts == nil {
+		hosts = newHostPool(cfg)
+	}
+	c := &Conn{cfg: cfg, hosts: hosts, backend: backend, hooks: h, metrics: newMetrics()}
+
+	start := time.Now()
+	err := c.intercept(&Event{Op: OpConnect}, func() error {
+		return c.open(ctx)
+	})
+	c.observe(OpConnect, start, err)
+	if err != nil {
 		return nil, err
 	}
 
@@ -31,62 +91,427 @@ func NewConn(dsn string) (*Conn, error) {
 }
 
 // Open the database connection
-func (c *Conn) open() error {
+func (c *Conn) open(ctx context.Context) error {
+	// a Config which wasn't parsed from a DSN hasn't been checked
+	if err := c.cfg.checkCharset(); err != nil {
+		return err
+	}
+
+	opts := &bridge.Options{
+		ConnectAttrs: c.cfg.connectAttrs(),
+		Charset:      c.cfg.charset(),
+		InitCommands: c.cfg.InitCommands,
+		RequireTLS:   c.cfg.RequireTLS,
+		OptionFile:   c.cfg.OptionFile,
+		OptionGroup:  c.cfg.OptionGroup,
+		Auth: bridge.AuthOptions{
+			DefaultAuth:        c.cfg.DefaultAuth,
+			PluginDir:          c.cfg.PluginDir,
+			EnableCleartext:    c.cfg.AllowCleartextPasswords,
+			ServerPublicKey:    c.cfg.ServerPublicKey,
+			GetServerPublicKey: c.cfg.GetServerPublicKey,
+		},
+	}
+	if c.cfg.LocalInfile {
+		opts.LocalInfile = openLocalInfile
+	}
+
+	user, pass := c.cfg.User, c.cfg.Pass
+	if c.cfg.Credentials != nil {
+		creds, err := c.cfg.Credentials.Credentials(ctx)
+		if err != nil {
+			return fmt.Errorf("Failed to get credentials: %w", err)
+		}
+
+		if creds.User != "" {
+			user = creds.User
+		}
+		pass = creds.Pass
+		opts.Auth.EnableCleartext = opts.Auth.EnableCleartext || creds.Cleartext
+		opts.RequireTLS = opts.RequireTLS || creds.RequireTLS
+	}
+
 	var err error
+	for _, addr := range c.hosts.order() {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		err = c.backend.Connect(
+			addr.Host, addr.Port,
+			user, pass,
+			c.cfg.Database,
+			opts,
+		)
+		if err == nil || !isConnectError(err) {
+			c.addr = addr
+			break
+		}
+		c.hosts.record(addr, false)
+	}
+	if err != nil {
+		return authError(err, c.cfg)
+	}
+	c.hosts.record(c.addr, true)
+
+	if err = c.initSession(); err != nil {
+		c.backend.Close()
+		return err
+	}
+
+	return nil
+}
+
+// Runs the connector's OnConnect functions
+func (c *Conn) runOnConnect(ctx context.Context) error {
+	for _, fn := range c.onConnect {
+		if err := fn(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sets up the session of a new or reset connection
+func (c *Conn) initSession() error {
+	if err := c.setNames(); err != nil {
+		return err
+	}
+	return c.setParams()
+}
+
+// The handshake only carries the charset's id, which a server that doesn't
+// know it silently replaces with its default, so the charset is set again
+// with SET NAMES for the server to reject it.
+func (c *Conn) setNames() error {
+	charset := c.cfg.charset()
+	if charset == "" {
+		return nil
+	}
+
+	query := "SET NAMES " + charset
+	if c.cfg.Collation != "" {
+		query += " COLLATE " + c.cfg.Collation
+	}
+
+	if err := c.backend.Execute(query); err != nil {
+		return fmt.Errorf("Failed to set charset %s: %w", charset, err)
+	}
+
+	return nil
+}
+
+// Sets the configured session variables in a single statement
+func (c *Conn) setParams() error {
+	if len(c.cfg.Params) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(c.cfg.Params))
+	for name := range c.cfg.Params {
+		if !rName.MatchString(name) {
+			return fmt.Errorf("Invalid session variable name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var query strings.Builder
+	args := make([]driver.Value, len(names))
+	for i, name := range names {
+		if i == 0 {
+			query.WriteString("SET ")
+		} else {
+			query.WriteString(", ")
+		}
+		query.WriteString(name + " = %s")
+		args[i] = sysvarValue(c.cfg.Params[name])
+	}
+
+	escaped, err := c.escapeQuery(query.String(), args)
+	if err == nil {
+		err = c.backend.Execute(escaped)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to set session variables: %w", err)
+	}
+
+	return nil
+}
+
+// numeric variables must be set with numbers, which the server does not
+// convert from strings
+func sysvarValue(val string) driver.Value {
+	if !rNumber.MatchString(val) {
+		return val
+	}
+	if i, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(val, 64); err == nil {
+		return f
+	}
+	return val
+}
+
+// IsValid implements the sql/driver Validator interface, so that database/sql
+// discards a connection which was lost instead of returning it to the pool
+func (c *Conn) IsValid() bool {
+	return !c.lost
+}
 
-	c.bridge, err = bridge.NewBridge(
-		c.cfg.host, c.cfg.port,
-		c.cfg.user, c.cfg.pass,
-		c.cfg.database,
-	)
+// Marks the connection lost when err means the server can't be reached.  A
+// command which couldn't be sent is reported as driver.ErrBadConn, so that
+// database/sql runs it on another connection, but one lost while it ran may
+// have been applied, so its error is returned as is.
+func (c *Conn) checkLost(err error) error {
+	var myErr *bridge.MySQLError
+	if !errors.As(err, &myErr) || !lostErrnos[myErr.Errno] {
+		return err
+	}
 
+	c.lost = true
+	if myErr.Errno == 2006 {
+		return &badConnError{err}
+	}
 	return err
 }
 
+// a driver.ErrBadConn which keeps the error behind it
+type badConnError struct {
+	err error
+}
+
+func (e *badConnError) Error() string {
+	return e.err.Error()
+}
+
+func (e *badConnError) Unwrap() error {
+	return e.err
+}
+
+func (e *badConnError) Is(target error) bool {
+	return target == driver.ErrBadConn
+}
+
+// ResetSession implements the sql/driver SessionResetter interface, it
+// resets the session and sets it up again when enabled with ResetSession.
+// The reset undoes everything done by the init commands and OnConnect
+// functions, so they are run again as on a new connection.
+func (c *Conn) ResetSession(ctx context.Context) error {
+	if c.lost {
+		return driver.ErrBadConn
+	}
+	if !c.cfg.ResetSession {
+		return nil
+	}
+
+	if b, ok := c.backend.(resetBackend); ok {
+		if err := b.Reset(); err != nil {
+			return driver.ErrBadConn
+		}
+	}
+
+	// the library only runs the init commands when it connects
+	for _, command := range c.cfg.InitCommands {
+		if err := c.backend.Execute(command); err != nil {
+			return driver.ErrBadConn
+		}
+	}
+
+	if err := c.initSession(); err != nil {
+		return driver.ErrBadConn
+	}
+	if err := c.runOnConnect(ctx); err != nil {
+		return driver.ErrBadConn
+	}
+
+	return nil
+}
+
+// Charset returns the character set of the connection
+func (c *Conn) Charset() string {
+	if b, ok := c.backend.(charsetBackend); ok {
+		return b.Charset()
+	}
+	return c.cfg.charset()
+}
+
+func (c *Conn) escapeQuery(query string, args []driver.Value) (string, error) {
+	return escape.EscapeQueryWith(query, args, c.backend.Escape)
+}
+
+// Escapes the query, returning the event describing it for the interceptors
+func (c *Conn) queryEvent(op Op, query string, args []driver.Value) (*Event, error) {
+	escaped, err := c.escapeQuery(query, args)
+	if err != nil {
+		escaped = query
+	}
+
+	return &Event{Op: op, Query: escaped, RawQuery: query, Args: args}, err
+}
+
 // MemSQL does not support prepared statements at this time
 func (c *Conn) Prepare(query string) (driver.Stmt, error) {
 	// not implemented
 	panic("Prepare is not supported by the mysqldb driver")
 }
 
-// MemSQL does not support prepared multi-statement transactions at this time
-func (c *Conn) Begin() (driver.Tx, error) {
-	panic("Begin is not supported by the mysqldb driver")
-}
-
 func (c *Conn) Close() error {
-	c.bridge.Close()
-	c.bridge = nil
-	return nil
+	return c.intercept(&Event{Op: OpClose}, c.backend.Close)
 }
 
 // implements the sql/driver Execer interface
-func (c *Conn) Exec(query string, args []driver.Value) (res driver.Result, err error) {
-	query, err = escape.EscapeQuery(query, args)
+func (c *Conn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return c.exec(context.Background(), query, args)
+}
+
+// implements the sql/driver ExecerContext interface.  The context is passed
+// to the Tracer and checked before the query is sent, but libmysql can not
+// interrupt a query once it is running.
+func (c *Conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	values, err := namedValues(args)
 	if err != nil {
 		return nil, err
 	}
+	return c.exec(ctx, query, values)
+}
+
+func (c *Conn) exec(ctx context.Context, query string, args []driver.Value) (driver.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.lost {
+		return nil, driver.ErrBadConn
+	}
+
+	e, err := c.queryEvent(OpExec, query, args)
+	span := c.startSpan(ctx, e)
+	start := time.Now()
 
-	if err = c.bridge.Execute(query); err != nil {
+	err = c.intercept(e, func() error {
+		if err != nil {
+			return err
+		}
+		if err := c.backend.Execute(e.Query); err != nil {
+			return err
+		}
+		e.RowsAffected = c.backend.RowsAffected()
+		return nil
+	})
+	err = c.checkLost(err)
+	c.observe(OpExec, start, err)
+	endSpan(span, err, e.RowsAffected)
+	if err != nil {
 		return nil, err
 	}
 
 	return &execResult{
-		rowsAffected: c.bridge.RowsAffected(),
-		lastInsertId: c.bridge.LastInsertID(),
+		rowsAffected: e.RowsAffected,
+		lastInsertId: c.backend.LastInsertID(),
 	}, nil
 }
 
 // implements the sql/driver Queryer interface
-func (c *Conn) Query(query string, args []driver.Value) (res driver.Rows, err error) {
-	query, err = escape.EscapeQuery(query, args)
+func (c *Conn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return c.query(context.Background(), query, args)
+}
+
+// implements the sql/driver QueryerContext interface, see ExecContext
+func (c *Conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	values, err := namedValues(args)
 	if err != nil {
 		return nil, err
 	}
+	return c.query(ctx, query, values)
+}
+
+func (c *Conn) query(ctx context.Context, query string, args []driver.Value) (driver.Rows, error) {
+	if c.cfg.Buffered {
+		rows, err := c.queryBuffered(ctx, query, args)
+		if err != nil {
+			return nil, err
+		}
+		return rows, nil
+	}
 
-	if err = c.bridge.Query(query); err != nil {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.lost {
+		return nil, driver.ErrBadConn
+	}
+
+	e, err := c.queryEvent(OpQuery, query, args)
+	span := c.startSpan(ctx, e)
+	start := time.Now()
+
+	err = c.intercept(e, func() error {
+		if err != nil {
+			return err
+		}
+		return c.backend.Query(e.Query)
+	})
+	err = c.checkLost(err)
+	c.observe(OpQuery, start, err)
+	if err != nil {
+		endSpan(span, err, 0)
 		return nil, err
 	}
 
-	return newStreamingResult(c), nil
+	return newStreamingResult(c, c.trackRows(e, span)), nil
+}
+
+// QueryBuffered runs the query and reads the entire result set into client
+// memory, leaving the connection free for other commands while the rows are
+// iterated.
+func (c *Conn) QueryBuffered(query string, args []driver.Value) (*BufferedRows, error) {
+	return c.queryBuffered(context.Background(), query, args)
+}
+
+func (c *Conn) queryBuffered(ctx context.Context, query string, args []driver.Value) (*BufferedRows, error) {
+	backend, ok := c.backend.(BufferingBackend)
+	if !ok {
+		return nil, errBufferingUnsupported
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.lost {
+		return nil, driver.ErrBadConn
+	}
+
+	e, err := c.queryEvent(OpQuery, query, args)
+	span := c.startSpan(ctx, e)
+	start := time.Now()
+
+	var res StoredResult
+	err = c.intercept(e, func() error {
+		if err != nil {
+			return err
+		}
+		res, err = backend.QueryBuffered(e.Query)
+		return err
+	})
+	err = c.checkLost(err)
+	c.observe(OpQuery, start, err)
+	if err != nil {
+		endSpan(span, err, 0)
+		return nil, err
+	}
+
+	return newBufferedRows(c, res, c.trackRows(e, span)), nil
+}
+
+// args are interpolated in order, so names are not supported
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errNamedArgs
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
 }
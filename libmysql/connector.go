@@ -0,0 +1,78 @@
+package libmysql
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// Connector implements the sql/driver Connector interface
+type Connector struct {
+	cfg        *Config
+	hosts      *hostPool
+	newBackend func() Backend
+	hooks      hooks
+}
+
+// NewConnector returns a connector for use with sql.OpenDB, which opens every
+// connection with a backend created by newBackend.  A nil newBackend uses
+// libmysqlclient.
+//
+//	fake := libmysql.NewFake()
+//	connector, err := libmysql.NewConnector("root@localhost:3306", fake.NewBackend)
+//	db := sql.OpenDB(connector)
+func NewConnector(dsn string, newBackend func() Backend) (*Connector, error) {
+	cfg, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConnectorConfig(cfg, newBackend), nil
+}
+
+// NewConnectorConfig is NewConnector for a Config built by hand, or a parsed
+// DSN which has been modified.  The config must not be modified afterwards.
+func NewConnectorConfig(cfg *Config, newBackend func() Backend) *Connector {
+	if newBackend == nil {
+		newBackend = newBridgeBackend
+	}
+
+	return &Connector{cfg: cfg, hosts: newHostPool(cfg), newBackend: newBackend}
+}
+
+// AddInterceptor adds an interceptor to every connection opened afterwards,
+// it should be called before the connector is passed to sql.OpenDB
+func (c *Connector) AddInterceptor(i Interceptor) {
+	c.hooks.interceptors = append(c.hooks.interceptors, i)
+}
+
+// SetTracer starts a span for every exec and query on connections opened
+// afterwards, see AddInterceptor
+func (c *Connector) SetTracer(t Tracer) {
+	c.hooks.tracer = t
+}
+
+// OnConnect adds a function which is run on every connection opened
+// afterwards, once its session has been set up, and again whenever its
+// session is reset (see Config.ResetSession).  The connection is closed and
+// not used if the function returns an error, see AddInterceptor.
+func (c *Connector) OnConnect(fn func(ctx context.Context, conn *Conn) error) {
+	c.hooks.onConnect = append(c.hooks.onConnect, fn)
+}
+
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := newConn(ctx, c.cfg, c.hosts, c.newBackend(), c.hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = conn.runOnConnect(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (c *Connector) Driver() driver.Driver {
+	return &MySQLDBDriver{}
+}
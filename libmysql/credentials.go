@@ -0,0 +1,82 @@
+package libmysql
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CredentialProvider provides the credentials of every new connection, such
+// as rotating passwords or short lived tokens
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (*Credentials, error)
+}
+
+// Credentials are used to open a single connection
+type Credentials struct {
+	// the configured user is used when empty
+	User string
+	Pass string
+
+	// Cleartext sends the password with mysql_clear_password, as needed by
+	// tokens which the server verifies itself
+	Cleartext bool
+	// RequireTLS refuses to connect without TLS, so that a cleartext
+	// password is not sent unencrypted
+	RequireTLS bool
+}
+
+// FileCredentials reads the password from a file on every connect, such as
+// a secret which is rotated in place.  Trailing newlines are ignored.
+type FileCredentials struct {
+	// the configured user is used when empty
+	User         string
+	PasswordFile string
+
+	Cleartext  bool
+	RequireTLS bool
+}
+
+func (p *FileCredentials) Credentials(ctx context.Context) (*Credentials, error) {
+	pass, err := os.ReadFile(p.PasswordFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credentials{
+		User:       p.User,
+		Pass:       strings.TrimRight(string(pass), "\r\n"),
+		Cleartext:  p.Cleartext,
+		RequireTLS: p.RequireTLS,
+	}, nil
+}
+
+// EnvCredentials reads the user and password from environment variables on
+// every connect
+type EnvCredentials struct {
+	// the configured user is used when empty, or when the variable is unset
+	UserVar string
+	PassVar string
+
+	Cleartext  bool
+	RequireTLS bool
+}
+
+func (p *EnvCredentials) Credentials(ctx context.Context) (*Credentials, error) {
+	pass, ok := os.LookupEnv(p.PassVar)
+	if !ok {
+		return nil, fmt.Errorf("Environment variable %s is not set", p.PassVar)
+	}
+
+	creds := &Credentials{
+		Pass:       pass,
+		Cleartext:  p.Cleartext,
+		RequireTLS: p.RequireTLS,
+	}
+	if p.UserVar != "" {
+		creds.User = os.Getenv(p.UserVar)
+	}
+
+	return creds, nil
+}
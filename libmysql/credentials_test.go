@@ -0,0 +1,94 @@
+package libmysql
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
+	. "gopkg.in/check.v1"
+)
+
+type CredentialsSuite struct{}
+
+var _ = Suite(&CredentialsSuite{})
+
+// records the credentials connections are opened with
+type credentialsBackend struct {
+	Backend
+	user, pass string
+	opts       *bridge.Options
+}
+
+func (b *credentialsBackend) Connect(host string, port int, user, pass, database string, opts *bridge.Options) error {
+	b.user, b.pass, b.opts = user, pass, opts
+	return b.Backend.Connect(host, port, user, pass, database, opts)
+}
+
+func (s *CredentialsSuite) TestFileCredentials(c *C) {
+	path := filepath.Join(c.MkDir(), "password")
+	provider := &FileCredentials{User: "app", PasswordFile: path}
+
+	_, err := provider.Credentials(context.Background())
+	c.Assert(errors.Is(err, os.ErrNotExist), Equals, true)
+
+	c.Assert(os.WriteFile(path, []byte("first\n"), 0600), IsNil)
+	creds, err := provider.Credentials(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(*creds, DeepEquals, Credentials{User: "app", Pass: "first"})
+
+	// rotated in place
+	c.Assert(os.WriteFile(path, []byte("second"), 0600), IsNil)
+	creds, err = provider.Credentials(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(creds.Pass, Equals, "second")
+}
+
+func (s *CredentialsSuite) TestEnvCredentials(c *C) {
+	provider := &EnvCredentials{UserVar: "LIBMYSQL_TEST_USER", PassVar: "LIBMYSQL_TEST_PASS", Cleartext: true}
+
+	os.Unsetenv("LIBMYSQL_TEST_PASS")
+	_, err := provider.Credentials(context.Background())
+	c.Assert(err, ErrorMatches, "Environment variable LIBMYSQL_TEST_PASS is not set")
+
+	os.Setenv("LIBMYSQL_TEST_USER", "app")
+	os.Setenv("LIBMYSQL_TEST_PASS", "token-1")
+	defer os.Unsetenv("LIBMYSQL_TEST_USER")
+	defer os.Unsetenv("LIBMYSQL_TEST_PASS")
+
+	creds, err := provider.Credentials(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(*creds, DeepEquals, Credentials{User: "app", Pass: "token-1", Cleartext: true})
+
+	os.Setenv("LIBMYSQL_TEST_PASS", "token-2")
+	creds, err = provider.Credentials(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(creds.Pass, Equals, "token-2")
+}
+
+func (s *CredentialsSuite) TestConnect(c *C) {
+	os.Setenv("LIBMYSQL_TEST_PASS", "token-1")
+	defer os.Unsetenv("LIBMYSQL_TEST_PASS")
+
+	cfg := &Config{
+		User:        "root",
+		Pass:        "ignored",
+		Credentials: &EnvCredentials{PassVar: "LIBMYSQL_TEST_PASS", Cleartext: true, RequireTLS: true},
+	}
+	backend := &credentialsBackend{Backend: NewFake().NewBackend()}
+
+	conn, err := newConn(context.Background(), cfg, nil, backend, hooks{})
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	c.Assert(backend.user, Equals, "root")
+	c.Assert(backend.pass, Equals, "token-1")
+	c.Assert(backend.opts.Auth.EnableCleartext, Equals, true)
+	c.Assert(backend.opts.RequireTLS, Equals, true)
+
+	// connecting fails without credentials
+	os.Unsetenv("LIBMYSQL_TEST_PASS")
+	_, err = newConn(context.Background(), cfg, nil, NewFake().NewBackend(), hooks{})
+	c.Assert(err, ErrorMatches, "Failed to get credentials: .*")
+}
@@ -1,8 +1,19 @@
 package libmysql
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
 	. "gopkg.in/check.v1"
 )
 
@@ -81,6 +92,25 @@ func (s *DriverSuite) TestBasic(c *C) {
 	c.Assert(count, Equals, 10)
 }
 
+func (s *DriverSuite) TestNoBackslashEscapes(c *C) {
+	db, err := sql.Open("libmysql", s.dsn+"?sql_mode=NO_BACKSLASH_ESCAPES")
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	values := []string{"x' OR 1=1 -- ", `back\slash`, `\'`, "it''s"}
+	for _, val := range values {
+		_, err = db.Exec("INSERT INTO gotests.x (foo) VALUES (%s)", val)
+		c.Assert(err, IsNil)
+	}
+
+	for _, val := range values {
+		var count int
+		err = db.QueryRow("SELECT COUNT(*) FROM gotests.x WHERE foo = %s", val).Scan(&count)
+		c.Assert(err, IsNil)
+		c.Assert(count, Equals, 1, Commentf("%q", val))
+	}
+}
+
 func (s *DriverSuite) BenchmarkBasicInsertWithEscape(c *C) {
 	query := "INSERT INTO x (foo) VALUES (%s)"
 	for i := 0; i < c.N; i++ {
@@ -121,3 +151,327 @@ func (s *DriverSuite) BenchmarkBasicKVSelectNoEscape(c *C) {
 		c.Assert(err, IsNil)
 	}
 }
+
+func (s *DriverSuite) TestLoadDataLocalInfile(c *C) {
+	db, err := sql.Open("libmysql", s.dsn+"?localInfile=true")
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	RegisterReaderHandler("rows", func() io.Reader {
+		return strings.NewReader("1\tfoo\n2\tbar\n3\t\\N\n")
+	})
+	defer DeregisterReaderHandler("rows")
+
+	res, err := db.Exec("LOAD DATA LOCAL INFILE 'Reader::rows' INTO TABLE gotests.x")
+	c.Assert(err, IsNil)
+	affected, err := res.RowsAffected()
+	c.Assert(err, IsNil)
+	c.Assert(affected, Equals, int64(3))
+
+	// unregistered readers fail the statement without breaking the connection
+	_, err = db.Exec("LOAD DATA LOCAL INFILE 'Reader::missing' INTO TABLE gotests.x")
+	c.Assert(err, Not(IsNil))
+
+	// without the DSN parameter the client refuses to send local files
+	_, err = s.db.Exec("LOAD DATA LOCAL INFILE 'Reader::rows' INTO TABLE gotests.x")
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *DriverSuite) TestBatchInserter(c *C) {
+	b := NewBatchInserter(s.db, "gotests.x", []string{"foo"}, "")
+
+	for i := 0; i < 1000; i++ {
+		c.Assert(b.Add(fmt.Sprintf("row %d%%", i)), IsNil)
+	}
+	c.Assert(b.Flush(), IsNil)
+	c.Assert(b.RowsAffected(), Equals, int64(1000))
+	c.Assert(b.Chunks()[0].FirstInsertID, Equals, int64(1))
+
+	var count int
+	c.Assert(s.db.QueryRow("SELECT COUNT(*) FROM gotests.x WHERE foo LIKE 'row %%\\%%'").Scan(&count), IsNil)
+	c.Assert(count, Equals, 1000)
+}
+
+func (s *DriverSuite) TestBufferedQuery(c *C) {
+	for i := 0; i < 10; i++ {
+		s.mustExec(c, "INSERT INTO gotests.x (foo) VALUES (%s)", fmt.Sprintf("foo%d", i))
+	}
+
+	conn, err := s.db.Conn(context.Background())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	err = conn.Raw(func(dc interface{}) error {
+		mc := dc.(*Conn)
+
+		// streaming results hold the connection until closed
+		rows, err := mc.Query("SELECT * FROM gotests.x", nil)
+		c.Assert(err, IsNil)
+		_, err = mc.Exec("SELECT 1", nil)
+		c.Assert(errors.Is(err, ErrCommandsOutOfSync), Equals, true)
+		c.Assert(rows.Close(), IsNil)
+
+		buffered, err := mc.QueryBuffered("SELECT id, foo FROM gotests.x ORDER BY id", nil)
+		c.Assert(err, IsNil)
+		defer buffered.Close()
+		c.Assert(buffered.RowCount(), Equals, int64(10))
+
+		// buffered results leave the connection free
+		_, err = mc.Exec("INSERT INTO gotests.x (foo) VALUES ('bar')", nil)
+		c.Assert(err, IsNil)
+
+		dest := make([]driver.Value, 2)
+		c.Assert(buffered.SeekRow(5), IsNil)
+		c.Assert(buffered.Next(dest), IsNil)
+		c.Assert(string(dest[1].([]byte)), Equals, "foo5")
+
+		c.Assert(buffered.SeekRow(0), IsNil)
+		c.Assert(buffered.Next(dest), IsNil)
+		c.Assert(string(dest[1].([]byte)), Equals, "foo0")
+
+		c.Assert(buffered.SeekRow(11), Not(IsNil))
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	db, err := sql.Open("libmysql", s.dsn+"?buffered=true")
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	var count int
+	c.Assert(db.QueryRow("SELECT COUNT(*) FROM gotests.x").Scan(&count), IsNil)
+	c.Assert(count, Equals, 11)
+}
+
+func (s *DriverSuite) TestBatchedFetch(c *C) {
+	large := strings.Repeat("x", 100*1024)
+	s.mustExec(c, "ALTER TABLE gotests.x MODIFY foo longtext")
+	s.mustExec(c, "INSERT INTO gotests.x (foo) VALUES (NULL), ('small'), (%s), ('')", large)
+
+	for _, dsn := range []string{s.dsn + "?rowBatchSize=2", s.dsn + "?rowBatchSize=1"} {
+		db, err := sql.Open("libmysql", dsn)
+		c.Assert(err, IsNil)
+
+		rows, err := db.Query("SELECT foo FROM gotests.x ORDER BY id")
+		c.Assert(err, IsNil)
+
+		var values []sql.NullString
+		for rows.Next() {
+			var foo sql.NullString
+			c.Assert(rows.Scan(&foo), IsNil)
+			values = append(values, foo)
+		}
+		c.Assert(rows.Err(), IsNil)
+		c.Assert(rows.Close(), IsNil)
+		c.Assert(db.Close(), IsNil)
+
+		c.Assert(values, DeepEquals, []sql.NullString{
+			{}, {String: "small", Valid: true}, {String: large, Valid: true}, {String: "", Valid: true},
+		})
+	}
+}
+
+func (s *DriverSuite) benchmarkNarrowScan(c *C, dsn string) {
+	db, err := sql.Open("libmysql", dsn)
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	b := NewBatchInserter(s.db, "gotests.x", []string{"foo"}, "")
+	for i := 0; i < 10000; i++ {
+		c.Assert(b.Add("a"), IsNil)
+	}
+	c.Assert(b.Flush(), IsNil)
+
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		rows, err := db.Query("SELECT id FROM gotests.x")
+		c.Assert(err, IsNil)
+
+		var id int
+		for rows.Next() {
+			rows.Scan(&id)
+		}
+		rows.Close()
+	}
+}
+
+func (s *DriverSuite) BenchmarkNarrowScanBatched(c *C) {
+	s.benchmarkNarrowScan(c, s.dsn)
+}
+
+func (s *DriverSuite) BenchmarkNarrowScanPerRow(c *C) {
+	s.benchmarkNarrowScan(c, s.dsn+"?rowBatchSize=1")
+}
+
+func (s *DriverSuite) TestConcurrentPool(c *C) {
+	db, err := sql.Open("libmysql", s.dsn)
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	// keep churning connections so bridges are constantly created and closed
+	db.SetMaxOpenConns(8)
+	db.SetMaxIdleConns(2)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				if _, err := db.Exec("INSERT INTO gotests.x (foo) VALUES (%s)", fmt.Sprintf("%d-%d", i, j)); err != nil {
+					errs <- err
+					return
+				}
+
+				var count int
+				if err := db.QueryRow("SELECT COUNT(*) FROM gotests.x").Scan(&count); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Assert(err, IsNil)
+	}
+
+	var count int
+	c.Assert(db.QueryRow("SELECT COUNT(*) FROM gotests.x").Scan(&count), IsNil)
+	c.Assert(count, Equals, 64*50)
+}
+
+func (s *DriverSuite) TestUseAfterClose(c *C) {
+	conn, err := NewConn(s.dsn)
+	c.Assert(err, IsNil)
+	c.Assert(conn.Close(), IsNil)
+
+	// closing twice is harmless
+	c.Assert(conn.Close(), IsNil)
+
+	_, err = conn.Exec("SELECT 1", nil)
+	c.Assert(errors.Is(err, ErrConnClosed), Equals, true)
+
+	_, err = conn.Query("SELECT 1", nil)
+	stateErr, ok := err.(*bridge.StateError)
+	c.Assert(ok, Equals, true)
+	c.Assert(stateErr.State, Equals, bridge.StateClosed)
+}
+
+// forces garbage collection until cond holds, or gives up after a while
+func waitForGC(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		runtime.GC()
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func (s *DriverSuite) TestLeakCleanup(c *C) {
+	var mu sync.Mutex
+	leaked := map[string]string{}
+	SetLeakReporter(func(kind, stack string) {
+		mu.Lock()
+		leaked[kind] = stack
+		mu.Unlock()
+	})
+	defer SetLeakReporter(nil)
+
+	before := LiveHandles()
+
+	func() {
+		conn, err := NewConn(s.dsn)
+		c.Assert(err, IsNil)
+		_, err = conn.Query("SELECT 1", nil)
+		c.Assert(err, IsNil)
+		c.Assert(LiveHandles().Bridges, Equals, before.Bridges+1)
+		c.Assert(LiveHandles().StreamingResults, Equals, before.StreamingResults+1)
+	}()
+
+	c.Assert(waitForGC(func() bool {
+		now := LiveHandles()
+		return now.Bridges == before.Bridges && now.StreamingResults == before.StreamingResults
+	}), Equals, true)
+
+	mu.Lock()
+	defer mu.Unlock()
+	c.Assert(leaked["Bridge"], Matches, "(?s).*TestLeakCleanup.*")
+	c.Assert(leaked["streamingResult"], Matches, "(?s).*TestLeakCleanup.*")
+	c.Assert(LiveHandles().Leaked >= before.Leaked+2, Equals, true)
+}
+
+func (s *DriverSuite) TestXA(c *C) {
+	ctx := context.Background()
+	committed := XID{Gtrid: "gotests\x00'1"}
+	rolledBack := XID{Gtrid: "gotests-2", Bqual: "b\xff", FormatID: 7}
+
+	// prepared branches outlive their connection
+	for _, xid := range []XID{committed, rolledBack} {
+		conn, err := NewConn(s.dsn)
+		c.Assert(err, IsNil)
+
+		xa, err := conn.XAStart(ctx, xid)
+		c.Assert(err, IsNil)
+		_, err = conn.Exec("INSERT INTO gotests.x (foo) VALUES (%s)", []driver.Value{xid.Gtrid})
+		c.Assert(err, IsNil)
+		c.Assert(xa.End(ctx), IsNil)
+		c.Assert(xa.Prepare(ctx), IsNil)
+		c.Assert(conn.Close(), IsNil)
+	}
+
+	conn, err := NewConn(s.dsn)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	recovered := func() []XID {
+		xids, err := conn.XARecover(ctx)
+		c.Assert(err, IsNil)
+
+		var ours []XID
+		for _, xid := range xids {
+			if strings.HasPrefix(xid.Gtrid, "gotests") {
+				ours = append(ours, xid)
+			}
+		}
+		sort.Slice(ours, func(i, j int) bool { return ours[i].Gtrid < ours[j].Gtrid })
+		return ours
+	}
+
+	xids := recovered()
+	c.Assert(xids, DeepEquals, []XID{committed, rolledBack})
+
+	c.Assert(conn.XACommit(ctx, committed, false), IsNil)
+	c.Assert(conn.XARollback(ctx, rolledBack), IsNil)
+	c.Assert(recovered(), HasLen, 0)
+
+	// one phase commit skips the prepare
+	xa, err := conn.XAStart(ctx, XID{Gtrid: "gotests-3", FormatID: 1})
+	c.Assert(err, IsNil)
+	_, err = conn.Exec("INSERT INTO gotests.x (foo) VALUES (%s)", []driver.Value{"one phase"})
+	c.Assert(err, IsNil)
+	c.Assert(xa.End(ctx), IsNil)
+	c.Assert(xa.Commit(ctx, true), IsNil)
+
+	var foos []string
+	rows := s.mustQuery(c, "SELECT foo FROM gotests.x ORDER BY id")
+	for rows.Next() {
+		var foo string
+		c.Assert(rows.Scan(&foo), IsNil)
+		foos = append(foos, foo)
+	}
+	c.Assert(rows.Err(), IsNil)
+	c.Assert(foos, DeepEquals, []string{committed.Gtrid, "one phase"})
+
+	// an unknown branch is reported by the server
+	var myErr *bridge.MySQLError
+	c.Assert(errors.As(conn.XACommit(ctx, rolledBack, false), &myErr), Equals, true)
+	c.Assert(myErr.Errno, Equals, uint16(1397))
+}
@@ -2,24 +2,47 @@ package libmysql
 
 import (
 	"errors"
+	"fmt"
+	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
 var (
-	rDSN = regexp.MustCompile(`^(?:(?P<user>[[:word:]-.]+?)(?::(?P<pass>[[:word:]-.]+?))?@)?(?P<host>[[:word:]-.]+?)(?::(?P<port>[[:word:]-.]+?))?(?:\/(?P<database>[[:word:]-.]+?))?$`)
+	rDSN = regexp.MustCompile(`^(?:(?P<user>[[:word:]-.]+?)(?::(?P<pass>[[:word:]-.]+?))?@)?(?P<hosts>[[:word:]-.]+?(?::[[:word:]-.]+?)?(?:,[[:word:]-.]+?(?::[[:word:]-.]+?)?)*)(?:\/(?P<database>[[:word:]-.]+?))?(?:\?(?P<params>.*))?$`)
 
 	errInvalidDSN  = errors.New("Failed to parse DSN")
 	errInvalidPort = errors.New("Failed to parse valid port number from DSN")
+
+	// charset, collation and session variable names, which are sent unquoted
+	rName = regexp.MustCompile(`^[[:word:]]+$`)
+
+	// session variables in a DSN, which are lower case unlike the driver's
+	// own camelCase parameters
+	rSessionVar = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+	// the driver's parameters, so that a misspelling of one such as
+	// "localinfile" is rejected rather than sent as a session variable
+	driverParams = []string{
+		"localInfile", "buffered", "rowBatchSize", "charset", "collation",
+		"optionFile", "optionGroup", "defaultAuth", "pluginDir",
+		"allowCleartextPasswords", "serverPublicKey", "getServerPublicKey",
+		"requireTLS", "hostStrategy", "hostBackoff", "initCommand",
+		"connectAttrs", "resetSession",
+	}
 )
 
-// parse the provided dsn into a new config object
+// ParseDSN parses the provided dsn into a new Config
 // currently must include all fields:
-// user:password@host:port/database
-func parseDSN(dsn string) (*config, error) {
-	cfg := &config{}
+// user:password@host:port/database?param=value&...
+// several hosts may be given as host1:port,host2:port
+// parameter values are percent-decoded, but a + is kept as is rather than
+// decoded as a space, so that time_zone=+00:00 needn't be encoded
+func ParseDSN(dsn string) (*Config, error) {
+	cfg := &Config{}
 	var err error
-	var port uint64
 
 	match := rDSN.FindStringSubmatch(dsn)
 	if match == nil {
@@ -29,21 +52,25 @@ func parseDSN(dsn string) (*config, error) {
 	for i, name := range rDSN.SubexpNames() {
 		switch name {
 		case "user":
-			cfg.user = match[i]
+			cfg.User = match[i]
 		case "pass":
-			cfg.pass = match[i]
-		case "host":
-			cfg.host = match[i]
-		case "port":
-			if match[i] != "" {
-				port, err = strconv.ParseUint(match[i], 10, 0)
-				if err != nil {
-					return nil, errInvalidPort
-				}
-				cfg.port = int(port)
+			cfg.Pass = match[i]
+		case "hosts":
+			hosts, err := parseHosts(match[i])
+			if err != nil {
+				return nil, err
+			}
+
+			cfg.Host, cfg.Port = hosts[0].Host, hosts[0].Port
+			if len(hosts) > 1 {
+				cfg.Hosts = hosts
 			}
 		case "database":
-			cfg.database = match[i]
+			cfg.Database = match[i]
+		case "params":
+			if err = parseParams(cfg, match[i]); err != nil {
+				return nil, err
+			}
 		default:
 			continue
 		}
@@ -51,3 +78,132 @@ func parseDSN(dsn string) (*config, error) {
 
 	return cfg, nil
 }
+
+// parse the query string portion of the dsn into the Config
+func parseParams(cfg *Config, params string) error {
+	values, err := parseQuery(params)
+	if err != nil {
+		return errInvalidDSN
+	}
+
+	for key, vals := range values {
+		val := vals[len(vals)-1]
+
+		switch key {
+		case "localInfile":
+			cfg.LocalInfile, err = strconv.ParseBool(val)
+		case "buffered":
+			cfg.Buffered, err = strconv.ParseBool(val)
+		case "rowBatchSize":
+			cfg.RowBatchSize, err = strconv.Atoi(val)
+			if err == nil && cfg.RowBatchSize < 1 {
+				err = errInvalidDSN
+			}
+		case "charset":
+			cfg.Charset = val
+			if !rName.MatchString(val) {
+				err = errInvalidDSN
+			}
+		case "collation":
+			cfg.Collation = val
+			if !rName.MatchString(val) {
+				err = errInvalidDSN
+			}
+		case "optionFile":
+			cfg.OptionFile = val
+		case "optionGroup":
+			cfg.OptionGroup = val
+		case "defaultAuth":
+			cfg.DefaultAuth = val
+		case "pluginDir":
+			cfg.PluginDir = val
+		case "allowCleartextPasswords":
+			cfg.AllowCleartextPasswords, err = strconv.ParseBool(val)
+		case "serverPublicKey":
+			cfg.ServerPublicKey = val
+		case "getServerPublicKey":
+			cfg.GetServerPublicKey, err = strconv.ParseBool(val)
+		case "requireTLS":
+			cfg.RequireTLS, err = strconv.ParseBool(val)
+		case "hostStrategy":
+			cfg.HostStrategy, err = parseHostStrategy(val)
+		case "hostBackoff":
+			cfg.HostBackoff, err = time.ParseDuration(val)
+		case "initCommand":
+			// may be given more than once
+			cfg.InitCommands = append(cfg.InitCommands, vals...)
+		case "connectAttrs":
+			cfg.ConnectAttrs, err = parseConnectAttrs(val)
+		case "resetSession":
+			cfg.ResetSession, err = strconv.ParseBool(val)
+		default:
+			// any other parameter is a session variable
+			if !isSessionVar(key) {
+				return fmt.Errorf("Unknown DSN parameter %s", key)
+			}
+			if cfg.Params == nil {
+				cfg.Params = make(map[string]string)
+			}
+			cfg.Params[key] = val
+		}
+
+		if err != nil {
+			return fmt.Errorf("Invalid value for DSN parameter %s: %s", key, val)
+		}
+	}
+
+	return nil
+}
+
+// like url.ParseQuery, but without decoding + as a space
+func parseQuery(params string) (url.Values, error) {
+	values := make(url.Values)
+	for _, pair := range strings.Split(params, "&") {
+		if pair == "" {
+			continue
+		}
+
+		key, val, _ := strings.Cut(pair, "=")
+		key, err := url.PathUnescape(key)
+		if err != nil {
+			return nil, err
+		}
+		val, err = url.PathUnescape(val)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = append(values[key], val)
+	}
+
+	return values, nil
+}
+
+func isSessionVar(key string) bool {
+	if !rSessionVar.MatchString(key) {
+		return false
+	}
+	for _, param := range driverParams {
+		if strings.EqualFold(key, param) {
+			return false
+		}
+	}
+	return true
+}
+
+// parse connection attributes of the form key:value,key:value
+func parseConnectAttrs(val string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	if val == "" {
+		return attrs, nil
+	}
+
+	for _, pair := range strings.Split(val, ",") {
+		key, value, ok := strings.Cut(pair, ":")
+		if !ok || key == "" {
+			return nil, errInvalidDSN
+		}
+		attrs[key] = value
+	}
+
+	return attrs, nil
+}
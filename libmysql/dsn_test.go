@@ -12,40 +12,40 @@ var _ = Suite(&DSNSuite{})
 
 func testCombinations(c *C, user, pass, host string, port int, database string) {
 	var err error
-	var cfg *config
+	var cfg *Config
 
-	authParts := map[string]func(*config){
-		fmt.Sprintf("%s:%s@", user, pass): func(cfg *config) {
-			c.Assert(cfg.user, Equals, user)
-			c.Assert(cfg.pass, Equals, pass)
+	authParts := map[string]func(*Config){
+		fmt.Sprintf("%s:%s@", user, pass): func(cfg *Config) {
+			c.Assert(cfg.User, Equals, user)
+			c.Assert(cfg.Pass, Equals, pass)
 		},
-		fmt.Sprintf("%s@", user): func(cfg *config) {
-			c.Assert(cfg.user, Equals, user)
-			c.Assert(cfg.pass, Equals, "")
+		fmt.Sprintf("%s@", user): func(cfg *Config) {
+			c.Assert(cfg.User, Equals, user)
+			c.Assert(cfg.Pass, Equals, "")
 		},
-		fmt.Sprintf(""): func(cfg *config) {
-			c.Assert(cfg.user, Equals, "")
-			c.Assert(cfg.pass, Equals, "")
+		fmt.Sprintf(""): func(cfg *Config) {
+			c.Assert(cfg.User, Equals, "")
+			c.Assert(cfg.Pass, Equals, "")
 		},
 	}
 
-	connParts := map[string]func(*config){
-		fmt.Sprintf("%s:%d", host, port): func(cfg *config) {
-			c.Assert(cfg.host, Equals, host)
-			c.Assert(cfg.port, Equals, port)
+	connParts := map[string]func(*Config){
+		fmt.Sprintf("%s:%d", host, port): func(cfg *Config) {
+			c.Assert(cfg.Host, Equals, host)
+			c.Assert(cfg.Port, Equals, port)
 		},
-		fmt.Sprintf("%s", host): func(cfg *config) {
-			c.Assert(cfg.host, Equals, host)
-			c.Assert(cfg.port, Equals, 0)
+		fmt.Sprintf("%s", host): func(cfg *Config) {
+			c.Assert(cfg.Host, Equals, host)
+			c.Assert(cfg.Port, Equals, 0)
 		},
 	}
 
-	dbParts := map[string]func(*config){
-		fmt.Sprintf("/%s", database): func(cfg *config) {
-			c.Assert(cfg.database, Equals, database)
+	dbParts := map[string]func(*Config){
+		fmt.Sprintf("/%s", database): func(cfg *Config) {
+			c.Assert(cfg.Database, Equals, database)
 		},
-		fmt.Sprintf(""): func(cfg *config) {
-			c.Assert(cfg.database, Equals, "")
+		fmt.Sprintf(""): func(cfg *Config) {
+			c.Assert(cfg.Database, Equals, "")
 		},
 	}
 
@@ -56,7 +56,7 @@ func testCombinations(c *C, user, pass, host string, port int, database string)
 
 				fmt.Printf("Testing dsn: %s\n", dsn)
 
-				cfg, err = parseDSN(dsn)
+				cfg, err = ParseDSN(dsn)
 
 				c.Assert(err, IsNil)
 				authCheck(cfg)
@@ -84,7 +84,103 @@ func (s *DSNSuite) TestBasic(c *C) {
 	for _, dsn := range failList {
 		fmt.Printf("Testing bad dsn: %s\n", dsn)
 
-		_, err := parseDSN(dsn)
+		_, err := ParseDSN(dsn)
+		c.Assert(err, Not(IsNil))
+	}
+}
+
+func (s *DSNSuite) TestParams(c *C) {
+	cfg, err := ParseDSN("root@127.0.0.1:3306/db?localInfile=true")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Database, Equals, "db")
+	c.Assert(cfg.LocalInfile, Equals, true)
+
+	cfg, err = ParseDSN("root@127.0.0.1?localInfile=false&buffered=1")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Host, Equals, "127.0.0.1")
+	c.Assert(cfg.LocalInfile, Equals, false)
+	c.Assert(cfg.Buffered, Equals, true)
+
+	cfg, err = ParseDSN("root@127.0.0.1?rowBatchSize=1")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.RowBatchSize, Equals, 1)
+
+	cfg, err = ParseDSN("root@127.0.0.1?connectAttrs=service:billing,version:1.2.3,empty:")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.ConnectAttrs, DeepEquals, map[string]string{
+		"service": "billing",
+		"version": "1.2.3",
+		"empty":   "",
+	})
+
+	attrs := cfg.connectAttrs()
+	c.Assert(attrs["service"], Equals, "billing")
+	c.Assert(attrs["driver_name"], Equals, "go-libmysql")
+
+	cfg, err = ParseDSN("root@127.0.0.1?connectAttrs=driver_name:custom")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.connectAttrs()["driver_name"], Equals, "custom")
+
+	cfg, err = ParseDSN("root@127.0.0.1?charset=utf8mb4&collation=utf8mb4_bin")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Charset, Equals, "utf8mb4")
+	c.Assert(cfg.Collation, Equals, "utf8mb4_bin")
+
+	cfg, err = ParseDSN("root@127.0.0.1?collation=utf8mb4_0900_ai_ci")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.charset(), Equals, "utf8mb4")
+
+	cfg, err = ParseDSN("root@127.0.0.1?time_zone=%2B00:00&sql_mode=TRADITIONAL&resetSession=true")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.ResetSession, Equals, true)
+	c.Assert(cfg.Params, DeepEquals, map[string]string{
+		"time_zone": "+00:00",
+		"sql_mode":  "TRADITIONAL",
+	})
+
+	// a + is kept rather than decoded as a space
+	cfg, err = ParseDSN("root@127.0.0.1?time_zone=+00:00&sql_mode=A%2CB+C")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Params, DeepEquals, map[string]string{
+		"time_zone": "+00:00",
+		"sql_mode":  "A,B+C",
+	})
+
+	cfg, err = ParseDSN("root@127.0.0.1?initCommand=SET%20ROLE%20app&initCommand=USE%20x")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.InitCommands, DeepEquals, []string{"SET ROLE app", "USE x"})
+
+	cfg, err = ParseDSN("root@127.0.0.1?defaultAuth=caching_sha2_password&serverPublicKey=%2Fetc%2Fmysql%2Fkey.pem&allowCleartextPasswords=1")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.DefaultAuth, Equals, "caching_sha2_password")
+	c.Assert(cfg.ServerPublicKey, Equals, "/etc/mysql/key.pem")
+	c.Assert(cfg.AllowCleartextPasswords, Equals, true)
+	c.Assert(cfg.GetServerPublicKey, Equals, false)
+
+	cfg, err = ParseDSN("root@127.0.0.1?optionFile=%2Fetc%2Fmy.cnf&optionGroup=reporting")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.OptionFile, Equals, "/etc/my.cnf")
+	c.Assert(cfg.OptionGroup, Equals, "reporting")
+
+	failList := [...]string{
+		"root@127.0.0.1/db?localInfile=maybe",
+		"root@127.0.0.1/db?unknownParam=1",
+		"root@127.0.0.1/db?unknown-param=1",
+		"root@127.0.0.1/db?localinfile=true",
+		"root@127.0.0.1/db?Time_Zone=UTC",
+		"root@127.0.0.1/db?rowBatchSize=0",
+		"root@127.0.0.1/db?localInfile=%zz",
+		"root@127.0.0.1/db?connectAttrs=service",
+		"root@127.0.0.1/db?connectAttrs=:billing",
+		"root@127.0.0.1/db?charset=utf8mb4;DROP",
+		"root@127.0.0.1/db?collation=",
+		"root@127.0.0.1/db?getServerPublicKey=please",
+	}
+
+	for _, dsn := range failList {
+		fmt.Printf("Testing bad dsn: %s\n", dsn)
+
+		_, err := ParseDSN(dsn)
 		c.Assert(err, Not(IsNil))
 	}
 }
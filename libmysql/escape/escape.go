@@ -3,10 +3,12 @@ package escape
 import (
 	"bytes"
 	"database/sql/driver"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/carlsverre/go-libmysql/libmysql/bridge"
@@ -14,6 +16,10 @@ import (
 
 // Escapes the provided value such that it is ready to be inserted directly into a query
 func Escape(val driver.Value) (out string, err error) {
+	return escapeWith(val, bridge.EscapeString)
+}
+
+func escapeWith(val driver.Value, escapeString func(string) string) (out string, err error) {
 	switch val := val.(type) {
 	case int:
 		out = strconv.FormatInt(int64(val), 10)
@@ -26,9 +32,12 @@ func Escape(val driver.Value) (out string, err error) {
 	case bool:
 		out = strconv.FormatBool(val)
 	case string:
-		out = escapeString(val)
+		out = quote(val, escapeString)
 	case time.Time:
-		out = escapeTime(val)
+		out = escapeTime(val, escapeString)
+	case []byte:
+		// a hex literal is binary safe whatever the connection's charset
+		out = "X'" + hex.EncodeToString(val) + "'"
 	default:
 		if val == nil {
 			out = "NULL"
@@ -41,6 +50,12 @@ func Escape(val driver.Value) (out string, err error) {
 }
 
 func EscapeQuery(query string, args []driver.Value) (string, error) {
+	return EscapeQueryWith(query, args, bridge.EscapeString)
+}
+
+// Same as EscapeQuery, but escapes strings with the provided function rather
+// than libmysql's
+func EscapeQueryWith(query string, args []driver.Value, escapeString func(string) string) (string, error) {
 	var buf bytes.Buffer
 
 	end := len(query)
@@ -69,7 +84,7 @@ func EscapeQuery(query string, args []driver.Value) (string, error) {
 			if argIndex >= argsLen {
 				return "", errors.New("Not enough arguments provided")
 			}
-			out, err := Escape(args[argIndex])
+			out, err := escapeWith(args[argIndex], escapeString)
 			if err != nil {
 				return "", err
 			}
@@ -87,18 +102,22 @@ func EscapeQuery(query string, args []driver.Value) (string, error) {
 	return buf.String(), nil
 }
 
-func escapeString(val string) string {
-	out := bridge.EscapeString(val)
-	return "'" + out + "'"
+// Quotes the provided identifier (table, column, etc) with backticks
+func EscapeIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func quote(val string, escapeString func(string) string) string {
+	return "'" + escapeString(val) + "'"
 }
 
-func escapeTime(val time.Time) string {
+func escapeTime(val time.Time, escapeString func(string) string) string {
 	var out string
 
 	if val.IsZero() {
 		out = "'0000-00-00'"
 	} else {
-		out = escapeString(val.Format(time.RFC3339))
+		out = quote(val.Format(time.RFC3339), escapeString)
 	}
 
 	return out
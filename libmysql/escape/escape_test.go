@@ -31,6 +31,8 @@ func (s *EscapeSuite) TestBasic(c *C) {
 	escapeAndCompare(c, true, "true")
 	escapeAndCompare(c, false, "false")
 	escapeAndCompare(c, nil, "NULL")
+	escapeAndCompare(c, []byte("a'\x00"), "X'612700'")
+	escapeAndCompare(c, []byte{}, "X''")
 
 	testStrings := []strTuple{
 		strTuple{"test", "'test'"},
@@ -58,6 +60,13 @@ func (s *EscapeSuite) TestBasic(c *C) {
 	}
 }
 
+func (s *EscapeSuite) TestIdentifier(c *C) {
+	c.Check(EscapeIdentifier("foo"), Equals, "`foo`")
+	c.Check(EscapeIdentifier("foo bar"), Equals, "`foo bar`")
+	c.Check(EscapeIdentifier("foo`; DROP TABLE x"), Equals, "`foo``; DROP TABLE x`")
+	c.Check(EscapeIdentifier(""), Equals, "``")
+}
+
 func mustEscapeQuery(c *C, expected, query string, args ...driver.Value) {
 	out, err := EscapeQuery(query, args)
 	c.Assert(err, IsNil)
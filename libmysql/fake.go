@@ -0,0 +1,332 @@
+package libmysql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
+	"github.com/carlsverre/go-libmysql/libmysql/internal/textvalue"
+)
+
+const (
+	// the type reported for every column of a fake result set
+	fakeColumnType = 253 // MYSQL_TYPE_VAR_STRING
+)
+
+// Fake is a scriptable in-memory Backend for tests which do not have a MySQL
+// server.  Queries are expected in the order they were registered with
+// Expect, across every connection opened with NewBackend:
+//
+//	fake := libmysql.NewFake()
+//	fake.Expect(`^SELECT name FROM users WHERE id = 1$`).
+//		ReturnRows([]string{"name"}, []interface{}{"alice"})
+//	fake.Expect(`^DELETE FROM users`).ReturnResult(1, 0)
+//
+//	connector, _ := libmysql.NewConnector("root@localhost:3306", fake.NewBackend)
+//	db := sql.OpenDB(connector)
+//	...
+//	err := fake.ExpectationsMet()
+//
+// A Fake replaces the server but not the client library.  This package, and
+// the types Backend shares with the default backend, are built on the cgo
+// bridge, so tests using a Fake still need libmysqlclient's headers and
+// libraries to build and link.
+type Fake struct {
+	mu       sync.Mutex
+	expected []*FakeQuery
+	next     int
+}
+
+// FakeQuery is an expected query and the result it produces
+type FakeQuery struct {
+	pattern *regexp.Regexp
+
+	columns      []string
+	rows         [][][]byte
+	rowsAffected int64
+	insertID     int64
+	err          error
+}
+
+func NewFake() *Fake {
+	return &Fake{}
+}
+
+// Expect registers the next expected query.  The pattern is a regular
+// expression matched against the query after its arguments have been escaped.
+// Until a result is set the query succeeds without returning any rows.
+func (f *Fake) Expect(pattern string) *FakeQuery {
+	q := &FakeQuery{pattern: regexp.MustCompile(pattern)}
+
+	f.mu.Lock()
+	f.expected = append(f.expected, q)
+	f.mu.Unlock()
+
+	return q
+}
+
+// ReturnRows sets the result set of the query.  Values are converted to their
+// text representation, and nil values are returned as NULL.
+func (q *FakeQuery) ReturnRows(columns []string, rows ...[]interface{}) *FakeQuery {
+	q.columns = columns
+	q.rows = make([][][]byte, len(rows))
+	for i, row := range rows {
+		q.rows[i] = make([][]byte, len(row))
+		for j, val := range row {
+			q.rows[i][j] = textvalue.Format(val)
+		}
+	}
+	return q
+}
+
+// ReturnResult sets the rows affected and insert id reported for the query
+func (q *FakeQuery) ReturnResult(rowsAffected, insertID int64) *FakeQuery {
+	q.rowsAffected = rowsAffected
+	q.insertID = insertID
+	return q
+}
+
+// ReturnError makes the query fail with err
+func (q *FakeQuery) ReturnError(err error) *FakeQuery {
+	q.err = err
+	return q
+}
+
+// ExpectationsMet returns an error describing the expected queries which have
+// not been run
+func (f *Fake) ExpectationsMet() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.next == len(f.expected) {
+		return nil
+	}
+
+	remaining := make([]string, 0, len(f.expected)-f.next)
+	for _, q := range f.expected[f.next:] {
+		remaining = append(remaining, q.pattern.String())
+	}
+	return fmt.Errorf("Expected queries were not run: %s", strings.Join(remaining, ", "))
+}
+
+// NewBackend returns a new connection to the fake, for use with NewConnector
+func (f *Fake) NewBackend() Backend {
+	return &fakeBackend{fake: f}
+}
+
+// Consumes the next expected query, which must match query
+func (f *Fake) run(query string) (*FakeQuery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.next >= len(f.expected) {
+		return nil, fmt.Errorf("Unexpected query: %s", query)
+	}
+
+	q := f.expected[f.next]
+	if !q.pattern.MatchString(query) {
+		return nil, fmt.Errorf("Unexpected query: %s, expected a query matching %s", query, q.pattern)
+	}
+	f.next++
+
+	return q, q.err
+}
+
+// a single connection to a Fake
+type fakeBackend struct {
+	fake  *Fake
+	state bridge.State
+
+	fields []bridge.MySQLField
+	rows   [][][]byte
+	pos    int
+
+	rowsAffected int64
+	insertID     int64
+}
+
+// Init commands are run as expected queries, like libmysqlclient they fail
+// the connect
+func (b *fakeBackend) Connect(host string, port int, user, pass, database string, opts *bridge.Options) error {
+	if opts == nil {
+		return nil
+	}
+
+	for _, command := range opts.InitCommands {
+		if _, err := b.fake.run(command); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Starts a command, which is only allowed on an idle connection
+func (b *fakeBackend) start(op, query string) (*FakeQuery, error) {
+	if b.state != bridge.StateIdle {
+		return nil, &bridge.StateError{Op: op, State: b.state}
+	}
+
+	b.fields, b.rows, b.pos = nil, nil, 0
+	b.rowsAffected, b.insertID = 0, 0
+
+	q, err := b.fake.run(query)
+	if err != nil {
+		return nil, err
+	}
+
+	b.rowsAffected, b.insertID = q.rowsAffected, q.insertID
+	return q, nil
+}
+
+func (b *fakeBackend) Query(query string) error {
+	q, err := b.start("Query", query)
+	if err != nil {
+		return err
+	}
+
+	b.fields = fakeFields(q.columns)
+	b.rows = q.rows
+	if q.columns != nil {
+		b.state = bridge.StateStreaming
+	}
+	return nil
+}
+
+func (b *fakeBackend) Reset() error {
+	if b.state != bridge.StateIdle {
+		return &bridge.StateError{Op: "Reset", State: b.state}
+	}
+	return nil
+}
+
+func (b *fakeBackend) Execute(query string) error {
+	_, err := b.start("Execute", query)
+	return err
+}
+
+func (b *fakeBackend) QueryBuffered(query string) (StoredResult, error) {
+	q, err := b.start("QueryBuffered", query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fakeStoredResult{fields: fakeFields(q.columns), rows: q.rows}, nil
+}
+
+func fakeFields(columns []string) []bridge.MySQLField {
+	if columns == nil {
+		return nil
+	}
+
+	fields := make([]bridge.MySQLField, len(columns))
+	for i, name := range columns {
+		fields[i] = bridge.MySQLField{ColumnType: fakeColumnType, Name: name}
+	}
+	return fields
+}
+
+func (b *fakeBackend) Fields() []bridge.MySQLField {
+	return b.fields
+}
+
+func (b *fakeBackend) FetchRow() (*[][]byte, error) {
+	switch b.state {
+	case bridge.StateClosed:
+		return nil, &bridge.StateError{Op: "FetchRow", State: b.state}
+	case bridge.StateIdle:
+		return nil, nil
+	}
+
+	if b.pos >= len(b.rows) {
+		b.state = bridge.StateIdle
+		return nil, nil
+	}
+
+	row := b.rows[b.pos]
+	b.pos++
+	return &row, nil
+}
+
+func (b *fakeBackend) Flush() error {
+	if b.state == bridge.StateClosed {
+		return &bridge.StateError{Op: "Flush", State: b.state}
+	}
+
+	b.state = bridge.StateIdle
+	b.rows = nil
+	return nil
+}
+
+func (b *fakeBackend) RowsAffected() int64 {
+	return b.rowsAffected
+}
+
+func (b *fakeBackend) LastInsertID() int64 {
+	return b.insertID
+}
+
+// escapes the same characters as mysql_escape_string
+func (b *fakeBackend) Escape(val string) string {
+	var out strings.Builder
+
+	for i := 0; i < len(val); i++ {
+		switch c := val[i]; c {
+		case 0:
+			out.WriteString(`\0`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\032':
+			out.WriteString(`\Z`)
+		case '\\', '\'', '"':
+			out.WriteByte('\\')
+			out.WriteByte(c)
+		default:
+			out.WriteByte(c)
+		}
+	}
+
+	return out.String()
+}
+
+func (b *fakeBackend) Close() error {
+	b.state = bridge.StateClosed
+	return nil
+}
+
+// the result of a fake buffered query
+type fakeStoredResult struct {
+	fields []bridge.MySQLField
+	rows   [][][]byte
+	pos    int
+}
+
+func (r *fakeStoredResult) Fields() []bridge.MySQLField {
+	return r.fields
+}
+
+func (r *fakeStoredResult) NumRows() int64 {
+	return int64(len(r.rows))
+}
+
+func (r *fakeStoredResult) DataSeek(row int64) {
+	r.pos = int(row)
+}
+
+func (r *fakeStoredResult) FetchRow() *[][]byte {
+	if r.pos >= len(r.rows) {
+		return nil
+	}
+
+	row := r.rows[r.pos]
+	r.pos++
+	return &row
+}
+
+func (r *fakeStoredResult) Free() {
+	r.rows = nil
+}
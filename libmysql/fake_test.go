@@ -0,0 +1,289 @@
+package libmysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
+	. "gopkg.in/check.v1"
+)
+
+type FakeSuite struct {
+	fake *Fake
+	db   *sql.DB
+}
+
+var _ = Suite(&FakeSuite{})
+
+func (s *FakeSuite) SetUpTest(c *C) {
+	s.fake = NewFake()
+
+	connector, err := NewConnector("root@localhost:3306", s.fake.NewBackend)
+	c.Assert(err, IsNil)
+
+	s.db = sql.OpenDB(connector)
+	s.db.SetMaxOpenConns(1)
+}
+
+func (s *FakeSuite) TearDownTest(c *C) {
+	s.db.Close()
+}
+
+func (s *FakeSuite) TestExec(c *C) {
+	s.fake.Expect(`^INSERT INTO x VALUES \('it''s' = 'it\\'s'\)$`)
+	s.fake.Expect(`^INSERT INTO x VALUES \(1, 'it\\'s', NULL\)$`).ReturnResult(1, 42)
+
+	_, err := s.db.Exec("INSERT INTO x VALUES ('it''s' = %s)", "it's")
+	c.Assert(err, IsNil)
+
+	res, err := s.db.Exec("INSERT INTO x VALUES (%s, %s, %s)", 1, "it's", nil)
+	c.Assert(err, IsNil)
+
+	affected, err := res.RowsAffected()
+	c.Assert(err, IsNil)
+	c.Assert(affected, Equals, int64(1))
+
+	id, err := res.LastInsertId()
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, int64(42))
+
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *FakeSuite) TestQuery(c *C) {
+	s.fake.Expect(`^SELECT id, name FROM users$`).ReturnRows(
+		[]string{"id", "name"},
+		[]interface{}{1, "alice"},
+		[]interface{}{2, nil},
+	)
+
+	rows, err := s.db.Query("SELECT id, name FROM users")
+	c.Assert(err, IsNil)
+
+	cols, err := rows.Columns()
+	c.Assert(err, IsNil)
+	c.Assert(cols, DeepEquals, []string{"id", "name"})
+
+	var (
+		ids   []int
+		names []sql.NullString
+	)
+	for rows.Next() {
+		var id int
+		var name sql.NullString
+		c.Assert(rows.Scan(&id, &name), IsNil)
+		ids = append(ids, id)
+		names = append(names, name)
+	}
+	c.Assert(rows.Err(), IsNil)
+	c.Assert(rows.Close(), IsNil)
+
+	c.Assert(ids, DeepEquals, []int{1, 2})
+	c.Assert(names, DeepEquals, []sql.NullString{{String: "alice", Valid: true}, {}})
+}
+
+func (s *FakeSuite) TestQueryBuffered(c *C) {
+	s.fake.Expect(`^SELECT 1$`).ReturnRows([]string{"1"}, []interface{}{1}, []interface{}{2})
+
+	conn, err := newConn(context.Background(), &Config{}, nil, s.fake.NewBackend(), hooks{})
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	rows, err := conn.QueryBuffered("SELECT 1", nil)
+	c.Assert(err, IsNil)
+	c.Assert(rows.RowCount(), Equals, int64(2))
+	c.Assert(rows.SeekRow(1), IsNil)
+	c.Assert(rows.Close(), IsNil)
+}
+
+func (s *FakeSuite) TestQueryError(c *C) {
+	failure := errors.New("Table 'x' doesn't exist")
+	s.fake.Expect(`^SELECT \* FROM x$`).ReturnError(failure)
+
+	_, err := s.db.Query("SELECT * FROM x")
+	c.Assert(err, Equals, failure)
+}
+
+func (s *FakeSuite) TestUnexpectedQuery(c *C) {
+	s.fake.Expect(`^SELECT 1$`)
+
+	_, err := s.db.Exec("SELECT 2")
+	c.Assert(err, ErrorMatches, "Unexpected query: SELECT 2, expected a query matching .*")
+
+	_, err = s.db.Exec("SELECT 1")
+	c.Assert(err, IsNil)
+
+	_, err = s.db.Exec("SELECT 3")
+	c.Assert(err, ErrorMatches, "Unexpected query: SELECT 3")
+}
+
+func (s *FakeSuite) TestExpectationsMet(c *C) {
+	s.fake.Expect(`^SELECT 1$`)
+	s.fake.Expect(`^SELECT 2$`)
+
+	_, err := s.db.Exec("SELECT 1")
+	c.Assert(err, IsNil)
+
+	c.Assert(s.fake.ExpectationsMet(), ErrorMatches, `Expected queries were not run: \^SELECT 2\$`)
+}
+
+func (s *FakeSuite) TestCommandsOutOfSync(c *C) {
+	s.fake.Expect(`^SELECT 1$`).ReturnRows([]string{"1"}, []interface{}{1})
+
+	conn, err := newConn(context.Background(), &Config{}, nil, s.fake.NewBackend(), hooks{})
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	rows, err := conn.Query("SELECT 1", nil)
+	c.Assert(err, IsNil)
+
+	_, err = conn.Exec("SELECT 2", nil)
+	c.Assert(errors.Is(err, ErrCommandsOutOfSync), Equals, true)
+
+	c.Assert(rows.Close(), IsNil)
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *FakeSuite) TestCharset(c *C) {
+	s.fake.Expect(`^SET NAMES utf8mb4 COLLATE utf8mb4_unicode_ci$`)
+
+	conn, err := newConn(context.Background(), &Config{Collation: "utf8mb4_unicode_ci"}, nil, s.fake.NewBackend(), hooks{})
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	c.Assert(conn.Charset(), Equals, "utf8mb4")
+
+	unknown := &bridge.MySQLError{Errno: 1115, Message: "Unknown character set: 'utf16le'"}
+	s.fake.Expect(`^SET NAMES utf16le$`).ReturnError(unknown)
+
+	_, err = newConn(context.Background(), &Config{Charset: "utf16le"}, nil, s.fake.NewBackend(), hooks{})
+	c.Assert(err, ErrorMatches, "Failed to set charset utf16le: .*")
+	c.Assert(errors.Is(err, unknown), Equals, true)
+
+	// a Config which wasn't parsed from a DSN is checked as well
+	_, err = newConn(context.Background(), &Config{Charset: "utf8mb4; DROP TABLE x"}, nil, s.fake.NewBackend(), hooks{})
+	c.Assert(err, ErrorMatches, `Invalid charset "utf8mb4; DROP TABLE x"`)
+	_, err = newConn(context.Background(), &Config{Collation: "utf8mb4_bin COLLATE x"}, nil, s.fake.NewBackend(), hooks{})
+	c.Assert(err, ErrorMatches, `Invalid collation .*`)
+
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *FakeSuite) TestSessionParams(c *C) {
+	cfg := &Config{
+		Params: map[string]string{
+			"time_zone":                "+00:00",
+			"sql_mode":                 "it's",
+			"innodb_lock_wait_timeout": "10",
+			"long_query_time":          "0.5",
+		},
+		ResetSession: true,
+	}
+	set := `^SET innodb_lock_wait_timeout = 10, long_query_time = 0.5, sql_mode = 'it\\'s', time_zone = '\+00:00'$`
+
+	s.fake.Expect(set)
+	conn, err := newConn(context.Background(), cfg, nil, s.fake.NewBackend(), hooks{})
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	s.fake.Expect(set)
+	c.Assert(conn.ResetSession(context.Background()), IsNil)
+
+	s.fake.Expect(set).ReturnError(&bridge.MySQLError{Errno: 1231, Message: "Variable can't be set"})
+	c.Assert(conn.ResetSession(context.Background()), Equals, driver.ErrBadConn)
+
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *FakeSuite) TestOnConnect(c *C) {
+	connector, err := NewConnector("root@localhost:3306?initCommand=SET%20ROLE%20app", s.fake.NewBackend)
+	c.Assert(err, IsNil)
+
+	recorder := &recordingInterceptor{}
+	connector.AddInterceptor(recorder)
+
+	failed := errors.New("no tenant")
+	connector.OnConnect(func(ctx context.Context, conn *Conn) error {
+		if _, err := conn.ExecContext(ctx, "USE tenant_1", nil); err != nil {
+			return failed
+		}
+		return nil
+	})
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	s.fake.Expect(`^SET ROLE app$`)
+	s.fake.Expect(`^USE tenant_1$`)
+	c.Assert(db.Ping(), IsNil)
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+
+	// the hook fails, closing the connection
+	s.fake.Expect(`^SET ROLE app$`)
+	conn, err := connector.Connect(context.Background())
+	c.Assert(err, Equals, failed)
+	c.Assert(conn, IsNil)
+	c.Assert(recorder.before[len(recorder.before)-1], Equals, OpClose)
+
+	// the init command fails, so the hook isn't run
+	s.fake.Expect(`^SET ROLE app$`).ReturnError(&bridge.MySQLError{Errno: 3530, Message: "`app`@`%` is not granted"})
+	_, err = connector.Connect(context.Background())
+	c.Assert(err, ErrorMatches, "Error 3530: .*")
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *FakeSuite) TestResetSessionSetup(c *C) {
+	connector, err := NewConnector("root@localhost:3306?initCommand=SET%20ROLE%20app&time_zone=UTC&resetSession=true", s.fake.NewBackend)
+	c.Assert(err, IsNil)
+
+	connector.OnConnect(func(ctx context.Context, conn *Conn) error {
+		_, err := conn.ExecContext(ctx, "USE tenant_1", nil)
+		return err
+	})
+
+	s.fake.Expect(`^SET ROLE app$`)
+	s.fake.Expect(`^SET time_zone = 'UTC'$`)
+	s.fake.Expect(`^USE tenant_1$`)
+	driverConn, err := connector.Connect(context.Background())
+	c.Assert(err, IsNil)
+	conn := driverConn.(*Conn)
+	defer conn.Close()
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+
+	// the reset undoes the role and the hook's USE, so they are run again
+	s.fake.Expect(`^SET ROLE app$`)
+	s.fake.Expect(`^SET time_zone = 'UTC'$`)
+	s.fake.Expect(`^USE tenant_1$`)
+	c.Assert(conn.ResetSession(context.Background()), IsNil)
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+
+	s.fake.Expect(`^SET ROLE app$`).ReturnError(&bridge.MySQLError{Errno: 3530, Message: "`app`@`%` is not granted"})
+	c.Assert(conn.ResetSession(context.Background()), Equals, driver.ErrBadConn)
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *FakeSuite) TestTransactions(c *C) {
+	s.fake.Expect(`^START TRANSACTION$`)
+	s.fake.Expect(`^INSERT INTO x VALUES \(1\)$`)
+	s.fake.Expect(`^COMMIT$`)
+
+	tx, err := s.db.Begin()
+	c.Assert(err, IsNil)
+	_, err = tx.Exec("INSERT INTO x VALUES (%s)", 1)
+	c.Assert(err, IsNil)
+	c.Assert(tx.Commit(), IsNil)
+
+	s.fake.Expect(`^SET TRANSACTION ISOLATION LEVEL READ COMMITTED$`)
+	s.fake.Expect(`^START TRANSACTION READ ONLY$`)
+	s.fake.Expect(`^ROLLBACK$`)
+
+	tx, err = s.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: true})
+	c.Assert(err, IsNil)
+	c.Assert(tx.Rollback(), IsNil)
+
+	_, err = s.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelLinearizable})
+	c.Assert(err, ErrorMatches, "Unsupported isolation level Linearizable")
+
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
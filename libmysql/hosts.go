@@ -0,0 +1,220 @@
+package libmysql
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
+)
+
+const (
+	defaultHostBackoff = time.Second
+	maxHostBackoff     = time.Minute
+)
+
+// errors which mean the host could not be reached, so that another should be
+// tried
+var connectErrnos = map[uint16]bool{
+	bridge.ER_CON_COUNT_ERROR: true,
+	bridge.ER_SERVER_SHUTDOWN: true,
+	2002:                      true, // CR_CONNECTION_ERROR
+	2003:                      true, // CR_CONN_HOST_ERROR
+	2005:                      true, // CR_UNKNOWN_HOST
+	2006:                      true, // CR_SERVER_GONE_ERROR
+	2013:                      true, // CR_SERVER_LOST
+}
+
+// Address is the host and port of a server, a zero port is the default port
+type Address struct {
+	Host string
+	Port int
+}
+
+func (a Address) String() string {
+	if a.Port == 0 {
+		return a.Host
+	}
+	return net.JoinHostPort(a.Host, strconv.Itoa(a.Port))
+}
+
+// HostStrategy picks the host a new connection tries first, when several
+// are configured.  Hosts which couldn't be reached are tried last, until
+// their backoff expires.
+type HostStrategy int
+
+const (
+	// try the hosts in order
+	HostFailover HostStrategy = iota
+	// try the hosts in a random order
+	HostRandom
+	// start with the host after the one the previous connection started with
+	HostRoundRobin
+)
+
+func (s HostStrategy) String() string {
+	switch s {
+	case HostFailover:
+		return "failover"
+	case HostRandom:
+		return "random"
+	case HostRoundRobin:
+		return "roundRobin"
+	}
+	return fmt.Sprintf("HostStrategy(%d)", int(s))
+}
+
+func parseHostStrategy(val string) (HostStrategy, error) {
+	for _, s := range []HostStrategy{HostFailover, HostRandom, HostRoundRobin} {
+		if val == s.String() {
+			return s, nil
+		}
+	}
+	return 0, errInvalidDSN
+}
+
+// Addr returns the server the connection is connected to
+func (c *Conn) Addr() Address {
+	return c.addr
+}
+
+// the hosts of a Config, shared by every connection opened with it
+type hostPool struct {
+	addrs    []Address
+	strategy HostStrategy
+	backoff  time.Duration
+
+	mu   sync.Mutex
+	next int
+	down map[int]*hostDown
+}
+
+// a host which couldn't be reached
+type hostDown struct {
+	failures int
+	until    time.Time
+}
+
+func newHostPool(cfg *Config) *hostPool {
+	p := &hostPool{
+		addrs:    cfg.Hosts,
+		strategy: cfg.HostStrategy,
+		backoff:  cfg.HostBackoff,
+		down:     make(map[int]*hostDown),
+	}
+
+	if len(p.addrs) == 0 {
+		p.addrs = []Address{{Host: cfg.Host, Port: cfg.Port}}
+	}
+	if p.backoff <= 0 {
+		p.backoff = defaultHostBackoff
+	}
+
+	return p
+}
+
+// Returns the hosts in the order a new connection should try them
+func (p *hostPool) order() []Address {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := len(p.addrs)
+	order := make([]int, n)
+	switch p.strategy {
+	case HostRandom:
+		order = rand.Perm(n)
+	case HostRoundRobin:
+		for i := range order {
+			order[i] = (p.next + i) % n
+		}
+		p.next = (p.next + 1) % n
+	default:
+		for i := range order {
+			order[i] = i
+		}
+	}
+
+	// hosts which are down go last, those which come back up soonest first
+	now := time.Now()
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := p.downUntil(order[i], now), p.downUntil(order[j], now)
+		return a.Before(b)
+	})
+
+	addrs := make([]Address, n)
+	for i, idx := range order {
+		addrs[i] = p.addrs[idx]
+	}
+	return addrs
+}
+
+// must be called while holding mu, the zero time when the host is up
+func (p *hostPool) downUntil(idx int, now time.Time) time.Time {
+	if d := p.down[idx]; d != nil && d.until.After(now) {
+		return d.until
+	}
+	return time.Time{}
+}
+
+// Records whether the host could be reached, backing off of hosts which
+// repeatedly can't be
+func (p *hostPool) record(addr Address, up bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for idx, a := range p.addrs {
+		if a != addr {
+			continue
+		}
+
+		if up {
+			delete(p.down, idx)
+			continue
+		}
+
+		d := p.down[idx]
+		if d == nil {
+			d = &hostDown{}
+			p.down[idx] = d
+		}
+		d.failures++
+
+		backoff := p.backoff << min(d.failures-1, 16)
+		if backoff > maxHostBackoff {
+			backoff = maxHostBackoff
+		}
+		d.until = time.Now().Add(backoff)
+	}
+}
+
+// whether connecting to another host may succeed
+func isConnectError(err error) bool {
+	var myErr *bridge.MySQLError
+	return errors.As(err, &myErr) && connectErrnos[myErr.Errno]
+}
+
+// parse a comma separated list of host[:port]
+func parseHosts(val string) ([]Address, error) {
+	var addrs []Address
+	for _, hostPort := range strings.Split(val, ",") {
+		host, port, hasPort := strings.Cut(hostPort, ":")
+		addr := Address{Host: host}
+
+		if hasPort {
+			p, err := strconv.ParseUint(port, 10, 16)
+			if err != nil {
+				return nil, errInvalidPort
+			}
+			addr.Port = int(p)
+		}
+
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
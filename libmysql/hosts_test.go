@@ -0,0 +1,98 @@
+package libmysql
+
+import (
+	"time"
+
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
+	. "gopkg.in/check.v1"
+)
+
+type HostsSuite struct{}
+
+var _ = Suite(&HostsSuite{})
+
+var (
+	hostA = Address{Host: "a", Port: 3306}
+	hostB = Address{Host: "b", Port: 3307}
+	hostC = Address{Host: "c"}
+)
+
+func (s *HostsSuite) TestParse(c *C) {
+	cfg, err := ParseDSN("root:pw@a:3306,b:3307,c/db?hostStrategy=roundRobin&hostBackoff=5s")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Host, Equals, "a")
+	c.Assert(cfg.Port, Equals, 3306)
+	c.Assert(cfg.Hosts, DeepEquals, []Address{hostA, hostB, hostC})
+	c.Assert(cfg.HostStrategy, Equals, HostRoundRobin)
+	c.Assert(cfg.HostBackoff, Equals, 5*time.Second)
+	c.Assert(cfg.Database, Equals, "db")
+
+	cfg, err = ParseDSN("root@a:3306")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Hosts, IsNil)
+	c.Assert(newHostPool(cfg).order(), DeepEquals, []Address{hostA})
+
+	for _, dsn := range []string{
+		"root@a,,b",
+		"root@a:3306,b:x",
+		"root@a,:3306",
+		"root@a,b?hostStrategy=nearest",
+	} {
+		_, err := ParseDSN(dsn)
+		c.Assert(err, Not(IsNil), Commentf(dsn))
+	}
+
+	c.Assert(hostB.String(), Equals, "b:3307")
+	c.Assert(hostC.String(), Equals, "c")
+}
+
+func (s *HostsSuite) TestStrategies(c *C) {
+	hosts := []Address{hostA, hostB, hostC}
+
+	failover := newHostPool(&Config{Hosts: hosts})
+	c.Assert(failover.order(), DeepEquals, hosts)
+	c.Assert(failover.order(), DeepEquals, hosts)
+
+	roundRobin := newHostPool(&Config{Hosts: hosts, HostStrategy: HostRoundRobin})
+	c.Assert(roundRobin.order(), DeepEquals, []Address{hostA, hostB, hostC})
+	c.Assert(roundRobin.order(), DeepEquals, []Address{hostB, hostC, hostA})
+	c.Assert(roundRobin.order(), DeepEquals, []Address{hostC, hostA, hostB})
+
+	random := newHostPool(&Config{Hosts: hosts, HostStrategy: HostRandom})
+	first := make(map[Address]bool)
+	for i := 0; i < 100; i++ {
+		order := random.order()
+		c.Assert(order, HasLen, 3)
+		first[order[0]] = true
+	}
+	c.Assert(first, HasLen, 3)
+}
+
+func (s *HostsSuite) TestBackoff(c *C) {
+	pool := newHostPool(&Config{Hosts: []Address{hostA, hostB, hostC}, HostBackoff: time.Second})
+
+	pool.record(hostA, false)
+	c.Assert(pool.order(), DeepEquals, []Address{hostB, hostC, hostA})
+
+	// every host is down, the one which comes back up first is tried first
+	pool.record(hostA, false)
+	pool.record(hostC, false)
+	pool.record(hostB, false)
+	c.Assert(pool.order(), DeepEquals, []Address{hostC, hostB, hostA})
+	c.Assert(pool.down[0].until.Sub(pool.down[2].until) > 0, Equals, true)
+
+	pool.record(hostA, true)
+	c.Assert(pool.order(), DeepEquals, []Address{hostA, hostC, hostB})
+
+	// the backoff is capped, and expires
+	pool.backoff = time.Nanosecond
+	pool.record(hostC, false)
+	time.Sleep(time.Millisecond)
+	c.Assert(pool.order(), DeepEquals, []Address{hostA, hostC, hostB})
+}
+
+func (s *HostsSuite) TestConnectErrors(c *C) {
+	c.Assert(isConnectError(&bridge.MySQLError{Errno: 2003, Message: "Can't connect"}), Equals, true)
+	c.Assert(isConnectError(&bridge.MySQLError{Errno: 1045, Message: "Access denied"}), Equals, false)
+	c.Assert(isConnectError(errInvalidDSN), Equals, false)
+}
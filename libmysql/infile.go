@@ -0,0 +1,90 @@
+package libmysql
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// prefix used in LOAD DATA LOCAL INFILE statements to refer to a registered reader
+	readerPrefix = "Reader::"
+)
+
+var (
+	infileLock     sync.RWMutex
+	readerRegister = map[string]func() io.Reader{}
+	fileRegister   = map[string]bool{}
+)
+
+// RegisterReaderHandler registers a handler which provides the data for
+//
+//	LOAD DATA LOCAL INFILE 'Reader::<name>' INTO TABLE ...
+//
+// The handler is called once per statement.  If the returned reader
+// implements io.Closer it is closed when the transfer completes.
+//
+// LOAD DATA LOCAL INFILE must be enabled with the localInfile=true DSN
+// parameter.
+func RegisterReaderHandler(name string, handler func() io.Reader) {
+	infileLock.Lock()
+	readerRegister[name] = handler
+	infileLock.Unlock()
+}
+
+// DeregisterReaderHandler removes a handler added by RegisterReaderHandler
+func DeregisterReaderHandler(name string) {
+	infileLock.Lock()
+	delete(readerRegister, name)
+	infileLock.Unlock()
+}
+
+// RegisterLocalFile adds the provided path to the allowlist of files which
+// may be read by LOAD DATA LOCAL INFILE.  The path must match the one used in
+// the statement exactly.
+func RegisterLocalFile(filePath string) {
+	infileLock.Lock()
+	fileRegister[strings.Trim(filePath, `"`)] = true
+	infileLock.Unlock()
+}
+
+// DeregisterLocalFile removes a path added by RegisterLocalFile
+func DeregisterLocalFile(filePath string) {
+	infileLock.Lock()
+	delete(fileRegister, strings.Trim(filePath, `"`))
+	infileLock.Unlock()
+}
+
+// opens the data source for a LOAD DATA LOCAL INFILE statement
+func openLocalInfile(name string) (io.Reader, error) {
+	if strings.HasPrefix(name, readerPrefix) {
+		infileLock.RLock()
+		handler, ok := readerRegister[name[len(readerPrefix):]]
+		infileLock.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("Reader '%s' is not registered", name)
+		}
+
+		// called without the lock, so that it may register handlers
+		reader := handler()
+		if reader == nil {
+			return nil, fmt.Errorf("Reader '%s' is <nil>", name)
+		}
+		return reader, nil
+	}
+
+	infileLock.RLock()
+	registered := fileRegister[name]
+	infileLock.RUnlock()
+	if !registered {
+		return nil, fmt.Errorf("Local file '%s' is not registered", name)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,68 @@
+package libmysql
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type InfileSuite struct{}
+
+var _ = Suite(&InfileSuite{})
+
+func (s *InfileSuite) TestReaderHandler(c *C) {
+	RegisterReaderHandler("test", func() io.Reader {
+		return strings.NewReader("1\tfoo\n")
+	})
+	defer DeregisterReaderHandler("test")
+
+	r, err := openLocalInfile("Reader::test")
+	c.Assert(err, IsNil)
+
+	data, err := io.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "1\tfoo\n")
+
+	_, err = openLocalInfile("Reader::missing")
+	c.Assert(err, Not(IsNil))
+
+	DeregisterReaderHandler("test")
+	_, err = openLocalInfile("Reader::test")
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *InfileSuite) TestOneShotHandler(c *C) {
+	// a handler which removes itself must not deadlock
+	RegisterReaderHandler("once", func() io.Reader {
+		DeregisterReaderHandler("once")
+		return strings.NewReader("1\n")
+	})
+
+	_, err := openLocalInfile("Reader::once")
+	c.Assert(err, IsNil)
+	_, err = openLocalInfile("Reader::once")
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *InfileSuite) TestLocalFile(c *C) {
+	path := filepath.Join(c.MkDir(), "data.tsv")
+	c.Assert(os.WriteFile(path, []byte("1\tfoo\n"), 0644), IsNil)
+
+	// files must be explicitly allowed
+	_, err := openLocalInfile(path)
+	c.Assert(err, Not(IsNil))
+
+	RegisterLocalFile(path)
+	defer DeregisterLocalFile(path)
+
+	r, err := openLocalInfile(path)
+	c.Assert(err, IsNil)
+	defer r.(*os.File).Close()
+
+	data, err := io.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "1\tfoo\n")
+}
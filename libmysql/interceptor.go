@@ -0,0 +1,150 @@
+package libmysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// Op identifies the operation an Event describes
+type Op int
+
+const (
+	OpConnect Op = iota
+	OpExec
+	OpQuery
+	// the end of iterating the rows returned by a query
+	OpRows
+	OpClose
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpConnect:
+		return "connect"
+	case OpExec:
+		return "exec"
+	case OpQuery:
+		return "query"
+	case OpRows:
+		return "rows"
+	case OpClose:
+		return "close"
+	}
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// Event describes an operation on a connection.  The same Event is passed to
+// Before and After, with the results filled in for After.
+type Event struct {
+	Op Op
+
+	// the query sent to the server, after args were interpolated
+	Query string
+	// the query and args as passed to Exec or Query
+	RawQuery string
+	Args     []driver.Value
+
+	// set for After
+	Addr     Address
+	Duration time.Duration
+	Err      error
+	// rows affected by an exec
+	RowsAffected int64
+	// rows read from the result set, for OpRows
+	Rows int64
+}
+
+// Interceptor observes the operations of connections opened by a Connector.
+// Before is called before connect, exec, query and close, and After once
+// they complete.  OpRows is only passed to After, when a query's rows have
+// been read to the end or closed.
+//
+// Interceptors are called synchronously and must not use the connection.
+type Interceptor interface {
+	Before(e *Event)
+	After(e *Event)
+}
+
+// the observers of a connection's operations
+type hooks struct {
+	interceptors []Interceptor
+	tracer       Tracer
+	onConnect    []func(ctx context.Context, conn *Conn) error
+}
+
+// Runs op, passing e to the connection's interceptors
+func (c *Conn) intercept(e *Event, op func() error) error {
+	if len(c.interceptors) == 0 {
+		return op()
+	}
+
+	for _, i := range c.interceptors {
+		i.Before(e)
+	}
+
+	start := time.Now()
+	e.Err = op()
+	e.Duration = time.Since(start)
+	e.Addr = c.addr
+
+	for _, i := range c.interceptors {
+		i.After(e)
+	}
+
+	return e.Err
+}
+
+// reports the end of a result set to the interceptors and tracer, nil when
+// the connection has neither
+type rowsTracker struct {
+	interceptors []Interceptor
+	event        *Event
+	span         Span
+	start        time.Time
+	rows         int64
+	finished     bool
+}
+
+func (c *Conn) trackRows(query *Event, span Span) *rowsTracker {
+	if len(c.interceptors) == 0 && span == nil {
+		return nil
+	}
+
+	return &rowsTracker{
+		interceptors: c.interceptors,
+		event: &Event{
+			Op:       OpRows,
+			Query:    query.Query,
+			RawQuery: query.RawQuery,
+			Args:     query.Args,
+			Addr:     c.addr,
+		},
+		span:  span,
+		start: time.Now(),
+	}
+}
+
+func (t *rowsTracker) row() {
+	if t != nil {
+		t.rows++
+	}
+}
+
+func (t *rowsTracker) done(err error) {
+	if t == nil || t.finished {
+		return
+	}
+	t.finished = true
+
+	e := t.event
+	e.Err = err
+	e.Rows = t.rows
+	e.Duration = time.Since(t.start)
+	for _, i := range t.interceptors {
+		i.After(e)
+	}
+
+	endSpan(t.span, err, t.rows)
+}
@@ -0,0 +1,137 @@
+package libmysql
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type InterceptorSuite struct {
+	fake     *Fake
+	recorder *recordingInterceptor
+	db       *sql.DB
+}
+
+var _ = Suite(&InterceptorSuite{})
+
+// records every event passed to After
+type recordingInterceptor struct {
+	before []Op
+	events []Event
+}
+
+func (r *recordingInterceptor) Before(e *Event) {
+	r.before = append(r.before, e.Op)
+}
+
+func (r *recordingInterceptor) After(e *Event) {
+	r.events = append(r.events, *e)
+}
+
+func (s *InterceptorSuite) SetUpTest(c *C) {
+	s.fake = NewFake()
+	s.recorder = &recordingInterceptor{}
+
+	connector, err := NewConnector("root@localhost:3306", s.fake.NewBackend)
+	c.Assert(err, IsNil)
+	connector.AddInterceptor(s.recorder)
+
+	s.db = sql.OpenDB(connector)
+	s.db.SetMaxOpenConns(1)
+}
+
+func (s *InterceptorSuite) TestEvents(c *C) {
+	s.fake.Expect(`^INSERT`).ReturnResult(3, 0)
+	s.fake.Expect(`^SELECT`).ReturnRows([]string{"a"}, []interface{}{1}, []interface{}{2})
+	failure := errors.New("failed")
+	s.fake.Expect(`^DELETE`).ReturnError(failure)
+
+	_, err := s.db.Exec("INSERT INTO x VALUES (%s)", "it's")
+	c.Assert(err, IsNil)
+
+	rows, err := s.db.Query("SELECT a FROM x WHERE b = %s", 5)
+	c.Assert(err, IsNil)
+	for rows.Next() {
+	}
+	c.Assert(rows.Close(), IsNil)
+
+	_, err = s.db.Exec("DELETE FROM x")
+	c.Assert(err, Equals, failure)
+
+	c.Assert(s.db.Close(), IsNil)
+
+	c.Assert(s.recorder.before, DeepEquals, []Op{OpConnect, OpExec, OpQuery, OpExec, OpClose})
+
+	events := s.recorder.events
+	c.Assert(events, HasLen, 6)
+
+	c.Assert(events[0].Op, Equals, OpConnect)
+
+	c.Assert(events[1].Op, Equals, OpExec)
+	c.Assert(events[1].Query, Equals, `INSERT INTO x VALUES ('it\'s')`)
+	c.Assert(events[1].RawQuery, Equals, "INSERT INTO x VALUES (%s)")
+	c.Assert(events[1].RowsAffected, Equals, int64(3))
+	c.Assert(events[1].Err, IsNil)
+
+	c.Assert(events[2].Op, Equals, OpQuery)
+	c.Assert(events[2].Query, Equals, "SELECT a FROM x WHERE b = 5")
+
+	c.Assert(events[3].Op, Equals, OpRows)
+	c.Assert(events[3].Query, Equals, "SELECT a FROM x WHERE b = 5")
+	c.Assert(events[3].Rows, Equals, int64(2))
+
+	c.Assert(events[4].Op, Equals, OpExec)
+	c.Assert(events[4].Err, Equals, failure)
+
+	c.Assert(events[5].Op, Equals, OpClose)
+}
+
+func (s *InterceptorSuite) TestLogger(c *C) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	e := &Event{
+		Op:           OpExec,
+		Query:        "UPDATE users SET password = 'hunter2'",
+		RawQuery:     "UPDATE users SET password = %s",
+		Args:         []driver.Value{"hunter2"},
+		Duration:     time.Millisecond,
+		RowsAffected: 1,
+	}
+
+	NewLogger(log, nil).After(e)
+	c.Assert(strings.Contains(buf.String(), "hunter2"), Equals, false)
+
+	var entry map[string]interface{}
+	c.Assert(json.Unmarshal(buf.Bytes(), &entry), IsNil)
+	c.Assert(entry["level"], Equals, "DEBUG")
+	c.Assert(entry["query"], Equals, "UPDATE users SET password = %s")
+	c.Assert(entry["args"], DeepEquals, []interface{}{"?"})
+	c.Assert(entry["rows_affected"], Equals, float64(1))
+
+	buf.Reset()
+	NewLogger(log, &LoggerOptions{ShowArgs: true, SlowThreshold: time.Millisecond}).After(e)
+
+	entry = nil
+	c.Assert(json.Unmarshal(buf.Bytes(), &entry), IsNil)
+	c.Assert(entry["level"], Equals, "WARN")
+	c.Assert(entry["msg"], Equals, "libmysql slow exec")
+	c.Assert(entry["query"], Equals, "UPDATE users SET password = 'hunter2'")
+	c.Assert(entry["args"], DeepEquals, []interface{}{"hunter2"})
+
+	buf.Reset()
+	e.Err = errors.New("failed")
+	NewLogger(log, &LoggerOptions{Level: slog.LevelInfo}).After(e)
+
+	entry = nil
+	c.Assert(json.Unmarshal(buf.Bytes(), &entry), IsNil)
+	c.Assert(entry["level"], Equals, "ERROR")
+	c.Assert(entry["error"], Equals, "failed")
+}
@@ -0,0 +1,28 @@
+// Package textvalue encodes Go values the way MySQL's text protocol sends
+// them, for the Fake backend and the test server
+package textvalue
+
+import (
+	"fmt"
+	"time"
+)
+
+// Format returns the text protocol encoding of val, nil for NULL
+func Format(val interface{}) []byte {
+	switch val := val.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return val
+	case string:
+		return []byte(val)
+	case bool:
+		if val {
+			return []byte("1")
+		}
+		return []byte("0")
+	case time.Time:
+		return []byte(val.Format("2006-01-02 15:04:05"))
+	}
+	return []byte(fmt.Sprint(val))
+}
@@ -0,0 +1,49 @@
+package libmysql
+
+import (
+	"sync/atomic"
+
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
+)
+
+var (
+	liveStreamingResults atomic.Int64
+)
+
+// HandleStats counts the C resources currently held by the driver
+type HandleStats struct {
+	// open MySQL connections
+	Bridges int64
+	// streaming results which have not been closed
+	StreamingResults int64
+	// buffered results which have not been closed
+	BufferedResults int64
+	// connections and results which were garbage collected without being
+	// closed, and cleaned up by the driver
+	Leaked int64
+}
+
+// LiveHandles returns a snapshot of the resources held by the driver
+func LiveHandles() HandleStats {
+	counters := bridge.GetCounters()
+	return HandleStats{
+		Bridges:          counters.Bridges,
+		StreamingResults: liveStreamingResults.Load(),
+		BufferedResults:  counters.StoredResults,
+		Leaked:           counters.Leaked,
+	}
+}
+
+// SetLeakReporter enables leak reporting.  Every connection and result which
+// is garbage collected without being closed is passed to the reporter along
+// with the stack which allocated it, for example:
+//
+//	libmysql.SetLeakReporter(func(kind, stack string) {
+//		log.Printf("leaked %s allocated at:\n%s", kind, stack)
+//	})
+//
+// Recording allocation stacks is expensive, so this should only be enabled
+// while debugging.  Pass nil to disable reporting.
+func SetLeakReporter(reporter func(kind, stack string)) {
+	bridge.SetLeakReporter(reporter)
+}
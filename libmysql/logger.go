@@ -0,0 +1,117 @@
+package libmysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// LoggerOptions configures the interceptor returned by NewLogger
+type LoggerOptions struct {
+	// the level of ordinary events, slog.LevelDebug when nil
+	Level slog.Leveler
+
+	// operations taking at least this long are logged at slog.LevelWarn,
+	// zero disables slow query logging
+	SlowThreshold time.Duration
+
+	// log the query with its args interpolated and the values of its args.
+	// By default the query is logged before interpolation and args are
+	// replaced with "?", so that no values reach the log.
+	ShowArgs bool
+}
+
+// logs every completed operation with slog
+type logger struct {
+	log  *slog.Logger
+	opts LoggerOptions
+}
+
+// NewLogger returns an interceptor which logs every operation to log.  Failed
+// operations are logged at slog.LevelError.
+func NewLogger(log *slog.Logger, opts *LoggerOptions) Interceptor {
+	l := &logger{log: log}
+	if opts != nil {
+		l.opts = *opts
+	}
+	if l.opts.Level == nil {
+		l.opts.Level = slog.LevelDebug
+	}
+	return l
+}
+
+func (l *logger) Before(e *Event) {}
+
+func (l *logger) After(e *Event) {
+	slow := l.opts.SlowThreshold > 0 && e.Duration >= l.opts.SlowThreshold
+
+	level := l.opts.Level.Level()
+	switch {
+	case e.Err != nil:
+		level = slog.LevelError
+	case slow:
+		level = slog.LevelWarn
+	}
+
+	ctx := context.Background()
+	if !l.log.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("op", e.Op.String()),
+		slog.Duration("duration", e.Duration),
+	}
+
+	if e.Addr.Host != "" {
+		attrs = append(attrs, slog.String("addr", e.Addr.String()))
+	}
+
+	if e.RawQuery != "" {
+		if l.opts.ShowArgs {
+			attrs = append(attrs, slog.String("query", e.Query), slog.Any("args", formatArgs(e.Args)))
+		} else {
+			attrs = append(attrs, slog.String("query", e.RawQuery), slog.Any("args", redactArgs(e.Args)))
+		}
+	}
+
+	switch e.Op {
+	case OpExec:
+		attrs = append(attrs, slog.Int64("rows_affected", e.RowsAffected))
+	case OpRows:
+		attrs = append(attrs, slog.Int64("rows", e.Rows))
+	}
+
+	if e.Err != nil {
+		attrs = append(attrs, slog.String("error", e.Err.Error()))
+	}
+
+	msg := "libmysql " + e.Op.String()
+	if slow {
+		msg = "libmysql slow " + e.Op.String()
+	}
+
+	l.log.LogAttrs(ctx, level, msg, attrs...)
+}
+
+func formatArgs(args []driver.Value) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		if b, ok := arg.([]byte); ok {
+			out[i] = string(b)
+		} else {
+			out[i] = fmt.Sprint(arg)
+		}
+	}
+	return out
+}
+
+func redactArgs(args []driver.Value) []string {
+	out := make([]string, len(args))
+	for i := range out {
+		out[i] = "?"
+	}
+	return out
+}
@@ -0,0 +1,201 @@
+package libmysql
+
+import (
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
+)
+
+var (
+	// upper bounds of the latency histogram buckets
+	latencyBuckets = []time.Duration{
+		100 * time.Microsecond,
+		250 * time.Microsecond,
+		500 * time.Microsecond,
+		time.Millisecond,
+		2500 * time.Microsecond,
+		5 * time.Millisecond,
+		10 * time.Millisecond,
+		25 * time.Millisecond,
+		50 * time.Millisecond,
+		100 * time.Millisecond,
+		250 * time.Millisecond,
+		500 * time.Millisecond,
+		time.Second,
+		2500 * time.Millisecond,
+		5 * time.Second,
+		10 * time.Second,
+	}
+
+	driverMetrics = newMetrics()
+)
+
+// Histogram is a snapshot of a latency distribution
+type Histogram struct {
+	// the upper bounds of the buckets
+	Bounds []time.Duration
+	// Counts[i] counts observations no greater than Bounds[i], the final
+	// count is for observations larger than every bound
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Stats is a snapshot of the driver's metrics
+type Stats struct {
+	Queries int64
+	Execs   int64
+	// failed queries, execs and connects by MySQL error number, errors
+	// which did not come from MySQL are counted under 0
+	Errors map[uint16]int64
+
+	// rows read from result sets, and the bytes in their fields
+	RowsFetched int64
+	BytesRead   int64
+
+	ConnectLatency Histogram
+	// the latency of execs, and of queries until their first row is
+	// available
+	QueryLatency Histogram
+
+	// open libmysqlclient connections, only reported driver wide
+	LiveBridges int64
+}
+
+// DriverStats returns the metrics of every connection opened by the driver
+func DriverStats() Stats {
+	stats := driverMetrics.snapshot()
+	stats.LiveBridges = bridge.GetCounters().Bridges
+	return stats
+}
+
+// Stats returns the metrics of this connection
+func (c *Conn) Stats() Stats {
+	return c.metrics.snapshot()
+}
+
+type histogram struct {
+	bounds []time.Duration
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+}
+
+func newHistogram(bounds []time.Duration) histogram {
+	return histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+
+	h.counts[i]++
+	h.count++
+	h.sum += d
+}
+
+func (h *histogram) snapshot() Histogram {
+	return Histogram{
+		Bounds: append([]time.Duration(nil), h.bounds...),
+		Counts: append([]uint64(nil), h.counts...),
+		Count:  h.count,
+		Sum:    h.sum,
+	}
+}
+
+type metrics struct {
+	queries     atomic.Int64
+	execs       atomic.Int64
+	rowsFetched atomic.Int64
+	bytesRead   atomic.Int64
+
+	mu             sync.Mutex
+	errors         map[uint16]int64
+	connectLatency histogram
+	queryLatency   histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		errors:         make(map[uint16]int64),
+		connectLatency: newHistogram(latencyBuckets),
+		queryLatency:   newHistogram(latencyBuckets),
+	}
+}
+
+func (m *metrics) snapshot() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := Stats{
+		Queries:        m.queries.Load(),
+		Execs:          m.execs.Load(),
+		Errors:         make(map[uint16]int64, len(m.errors)),
+		RowsFetched:    m.rowsFetched.Load(),
+		BytesRead:      m.bytesRead.Load(),
+		ConnectLatency: m.connectLatency.snapshot(),
+		QueryLatency:   m.queryLatency.snapshot(),
+	}
+	for errno, n := range m.errors {
+		stats.Errors[errno] = n
+	}
+
+	return stats
+}
+
+func (m *metrics) observe(op Op, d time.Duration, err error) {
+	switch op {
+	case OpExec:
+		m.execs.Add(1)
+	case OpQuery:
+		m.queries.Add(1)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if op == OpConnect {
+		m.connectLatency.observe(d)
+	} else {
+		m.queryLatency.observe(d)
+	}
+
+	if err != nil {
+		var myErr *bridge.MySQLError
+		if errors.As(err, &myErr) {
+			m.errors[myErr.Errno]++
+		} else {
+			m.errors[0]++
+		}
+	}
+}
+
+func (m *metrics) row(fields []driver.Value) {
+	var n int64
+	for _, field := range fields {
+		if b, ok := field.([]byte); ok {
+			n += int64(len(b))
+		}
+	}
+
+	m.rowsFetched.Add(1)
+	m.bytesRead.Add(n)
+}
+
+// Records the metrics of a connection's operation, and the driver's
+func (c *Conn) observe(op Op, start time.Time, err error) {
+	d := time.Since(start)
+	c.metrics.observe(op, d, err)
+	driverMetrics.observe(op, d, err)
+}
+
+func (c *Conn) observeRow(fields []driver.Value) {
+	c.metrics.row(fields)
+	driverMetrics.row(fields)
+}
@@ -0,0 +1,67 @@
+package libmysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
+	. "gopkg.in/check.v1"
+)
+
+type MetricsSuite struct{}
+
+var _ = Suite(&MetricsSuite{})
+
+func (s *MetricsSuite) TestConnStats(c *C) {
+	fake := NewFake()
+	fake.Expect(`^SELECT`).ReturnRows([]string{"a", "b"}, []interface{}{"ab", nil}, []interface{}{"c", "d"})
+	fake.Expect(`^UPDATE`).ReturnResult(5, 0)
+	fake.Expect(`^DELETE`).ReturnError(&bridge.MySQLError{Errno: 1205, Message: "Lock wait timeout exceeded"})
+	fake.Expect(`^DELETE`).ReturnError(errors.New("failed"))
+
+	conn, err := newConn(context.Background(), &Config{}, nil, fake.NewBackend(), hooks{})
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	rows, err := conn.QueryBuffered("SELECT a, b FROM x", nil)
+	c.Assert(err, IsNil)
+	dest := make([]driver.Value, 2)
+	for rows.Next(dest) == nil {
+	}
+	c.Assert(rows.Close(), IsNil)
+
+	_, err = conn.Exec("UPDATE x SET a = 1", nil)
+	c.Assert(err, IsNil)
+	_, err = conn.Exec("DELETE FROM x", nil)
+	c.Assert(err, NotNil)
+	_, err = conn.Exec("DELETE FROM x", nil)
+	c.Assert(err, NotNil)
+
+	stats := conn.Stats()
+	c.Assert(stats.Queries, Equals, int64(1))
+	c.Assert(stats.Execs, Equals, int64(3))
+	c.Assert(stats.RowsFetched, Equals, int64(2))
+	c.Assert(stats.BytesRead, Equals, int64(4))
+	c.Assert(stats.Errors, DeepEquals, map[uint16]int64{1205: 1, 0: 1})
+	c.Assert(stats.ConnectLatency.Count, Equals, uint64(1))
+	c.Assert(stats.QueryLatency.Count, Equals, uint64(4))
+	c.Assert(stats.QueryLatency.Bounds, DeepEquals, latencyBuckets)
+	c.Assert(stats.QueryLatency.Counts, HasLen, len(latencyBuckets)+1)
+}
+
+func (s *MetricsSuite) TestHistogram(c *C) {
+	h := newMetrics().queryLatency
+
+	h.observe(50 * time.Microsecond)
+	h.observe(time.Millisecond)
+	h.observe(time.Minute)
+
+	snap := h.snapshot()
+	c.Assert(snap.Count, Equals, uint64(3))
+	c.Assert(snap.Sum, Equals, time.Minute+time.Millisecond+50*time.Microsecond)
+	c.Assert(snap.Counts[0], Equals, uint64(1))
+	c.Assert(snap.Counts[3], Equals, uint64(1))
+	c.Assert(snap.Counts[len(latencyBuckets)], Equals, uint64(1))
+}
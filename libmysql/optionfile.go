@@ -0,0 +1,319 @@
+package libmysql
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// how deeply !include directives may nest
+	maxIncludeDepth = 10
+
+	// the header of an obfuscated login path file, unused bytes followed by
+	// the key
+	loginPathHeaderLen = 4
+	loginPathKeyLen    = 20
+)
+
+var (
+	errLoginPath    = errors.New("Failed to decode login path file")
+	errIncludeDepth = errors.New("Option file includes are nested too deeply")
+)
+
+// ReadOptionFile loads the connection settings in a my.cnf style option file
+// into a new Config.  Only the named groups are read, [client] when none are
+// given, and options later in the file override earlier ones.  !include and
+// !includedir directives are followed, and login path files written by
+// mysql_config_editor (.mylogin.cnf) are read as well.  Options which don't
+// affect the driver are ignored, so that the file can be shared with the
+// mysql command line tools.
+func ReadOptionFile(path string, groups ...string) (*Config, error) {
+	if len(groups) == 0 {
+		groups = []string{"client"}
+	}
+
+	read := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		read[strings.ToLower(group)] = true
+	}
+
+	cfg := &Config{}
+	err := parseOptionFile(path, 0, func(group, key, val string, hasVal bool) error {
+		if !read[group] {
+			return nil
+		}
+		if err := applyOption(cfg, key, val, hasVal); err != nil {
+			return fmt.Errorf("Invalid value for option %s in %s: %s", key, path, val)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Calls visit with every option in the file, in order
+func parseOptionFile(path string, depth int, visit func(group, key, val string, hasVal bool) error) error {
+	if depth > maxIncludeDepth {
+		return errIncludeDepth
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if isLoginPathFile(data) {
+		if data, err = decodeLoginPathFile(data); err != nil {
+			return err
+		}
+	}
+
+	group := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+
+		case strings.HasPrefix(line, "!includedir"):
+			dir := includePath(path, strings.TrimPrefix(line, "!includedir"))
+			if err = parseOptionDir(dir, depth+1, visit); err != nil {
+				return err
+			}
+
+		case strings.HasPrefix(line, "!include"):
+			file := includePath(path, strings.TrimPrefix(line, "!include"))
+			if err = parseOptionFile(file, depth+1, visit); err != nil {
+				return err
+			}
+
+		case line[0] == '[':
+			if line[len(line)-1] != ']' {
+				return fmt.Errorf("Failed to parse option file %s line %d: %s", path, lineNo, line)
+			}
+			group = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+
+		default:
+			if group == "" {
+				return fmt.Errorf("Failed to parse option file %s line %d: option outside of a group", path, lineNo)
+			}
+
+			key, val, hasVal := strings.Cut(line, "=")
+			key = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "_", "-")
+			if hasVal {
+				val = optionValue(val)
+			}
+
+			if err = visit(group, key, val, hasVal); err != nil {
+				return err
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Parses the .cnf files in dir, in name order
+func parseOptionDir(dir string, depth int, visit func(group, key, val string, hasVal bool) error) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".cnf") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err = parseOptionFile(filepath.Join(dir, name), depth, visit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// relative includes are resolved from the including file's directory
+func includePath(from, path string) string {
+	path = strings.TrimSpace(path)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(from), path)
+}
+
+// Unquotes the value, or strips the comment which ends it
+func optionValue(val string) string {
+	val = strings.TrimSpace(val)
+
+	if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') {
+		if end := strings.LastIndexByte(val, val[0]); end > 0 {
+			return unescapeOption(val[1:end])
+		}
+	}
+
+	if i := strings.IndexByte(val, '#'); i >= 0 {
+		val = strings.TrimSpace(val[:i])
+	}
+	return unescapeOption(val)
+}
+
+func unescapeOption(val string) string {
+	if !strings.Contains(val, `\`) {
+		return val
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(val); i++ {
+		if val[i] != '\\' || i == len(val)-1 {
+			out.WriteByte(val[i])
+			continue
+		}
+
+		i++
+		switch val[i] {
+		case 'b':
+			out.WriteByte('\b')
+		case 't':
+			out.WriteByte('\t')
+		case 'n':
+			out.WriteByte('\n')
+		case 'r':
+			out.WriteByte('\r')
+		case 's':
+			out.WriteByte(' ')
+		default:
+			out.WriteByte(val[i])
+		}
+	}
+	return out.String()
+}
+
+// Sets the Config field for the option, unknown options are ignored
+func applyOption(cfg *Config, key, val string, hasVal bool) (err error) {
+	switch key {
+	case "host":
+		cfg.Host = val
+	case "port":
+		cfg.Port, err = strconv.Atoi(val)
+	case "user":
+		cfg.User = val
+	case "password":
+		// without a value the mysql client prompts for the password
+		if hasVal {
+			cfg.Pass = val
+		}
+	case "database":
+		cfg.Database = val
+	case "default-character-set":
+		cfg.Charset = val
+	case "init-command":
+		cfg.InitCommands = append(cfg.InitCommands, val)
+	case "local-infile":
+		cfg.LocalInfile, err = optionBool(val, hasVal)
+	case "default-auth":
+		cfg.DefaultAuth = val
+	case "plugin-dir":
+		cfg.PluginDir = val
+	case "enable-cleartext-plugin":
+		cfg.AllowCleartextPasswords, err = optionBool(val, hasVal)
+	case "server-public-key-path":
+		cfg.ServerPublicKey = val
+	case "get-server-public-key":
+		cfg.GetServerPublicKey, err = optionBool(val, hasVal)
+	case "ssl-mode":
+		switch strings.ToUpper(val) {
+		case "REQUIRED", "VERIFY_CA", "VERIFY_IDENTITY":
+			cfg.RequireTLS = true
+		case "DISABLED", "PREFERRED":
+			cfg.RequireTLS = false
+		default:
+			err = errInvalidDSN
+		}
+	}
+
+	return err
+}
+
+// boolean options are enabled by their name alone
+func optionBool(val string, hasVal bool) (bool, error) {
+	if !hasVal {
+		return true, nil
+	}
+
+	switch strings.ToLower(val) {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return strconv.ParseBool(val)
+}
+
+// login path files start with 4 unused zero bytes, which a text option file
+// never does
+func isLoginPathFile(data []byte) bool {
+	return len(data) >= loginPathHeaderLen && bytes.Equal(data[:loginPathHeaderLen], make([]byte, loginPathHeaderLen))
+}
+
+// Decodes a login path file written by mysql_config_editor.  Each line is
+// encrypted with AES-128-ECB, using a key folded from the one stored in the
+// file header, and prefixed with its little endian length.
+func decodeLoginPathFile(data []byte) ([]byte, error) {
+	if len(data) < loginPathHeaderLen+loginPathKeyLen {
+		return nil, errLoginPath
+	}
+
+	var key [16]byte
+	for i, b := range data[loginPathHeaderLen : loginPathHeaderLen+loginPathKeyLen] {
+		key[i%len(key)] ^= b
+	}
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	data = data[loginPathHeaderLen+loginPathKeyLen:]
+	for len(data) >= 4 {
+		n := int(binary.LittleEndian.Uint32(data))
+		data = data[4:]
+		if n == 0 || n > len(data) || n%aes.BlockSize != 0 {
+			return nil, errLoginPath
+		}
+
+		line := make([]byte, n)
+		for i := 0; i < n; i += aes.BlockSize {
+			block.Decrypt(line[i:i+aes.BlockSize], data[i:i+aes.BlockSize])
+		}
+		data = data[n:]
+
+		// PKCS#7 padding
+		pad := int(line[n-1])
+		if pad == 0 || pad > aes.BlockSize {
+			return nil, errLoginPath
+		}
+		out = append(out, line[:n-pad]...)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,147 @@
+package libmysql
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type OptionFileSuite struct {
+	dir string
+}
+
+var _ = Suite(&OptionFileSuite{})
+
+func (s *OptionFileSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *OptionFileSuite) write(c *C, name, contents string) string {
+	path := filepath.Join(s.dir, name)
+	c.Assert(os.MkdirAll(filepath.Dir(path), 0700), IsNil)
+	c.Assert(os.WriteFile(path, []byte(contents), 0600), IsNil)
+	return path
+}
+
+func (s *OptionFileSuite) TestGroups(c *C) {
+	path := s.write(c, "my.cnf", `
+# shared with the mysql cli
+[client]
+host = db.internal
+port=3307
+user = "app"
+password = 'it\'s # not a comment'
+default_character_set = utf8mb4   # trailing comment
+ssl-mode = REQUIRED
+init-command = SET ROLE app
+prompt = \u@\h>
+
+[mysql]
+auto-rehash
+
+[reporting]
+user = reporter
+database = reports
+enable-cleartext-plugin
+`)
+
+	cfg, err := ReadOptionFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(cfg, DeepEquals, &Config{
+		Host:         "db.internal",
+		Port:         3307,
+		User:         "app",
+		Pass:         "it's # not a comment",
+		Charset:      "utf8mb4",
+		RequireTLS:   true,
+		InitCommands: []string{"SET ROLE app"},
+	})
+
+	cfg, err = ReadOptionFile(path, "client", "reporting")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.User, Equals, "reporter")
+	c.Assert(cfg.Database, Equals, "reports")
+	c.Assert(cfg.Host, Equals, "db.internal")
+	c.Assert(cfg.AllowCleartextPasswords, Equals, true)
+}
+
+func (s *OptionFileSuite) TestIncludes(c *C) {
+	s.write(c, "conf.d/b.cnf", "[client]\nport = 3309\n")
+	s.write(c, "conf.d/a.cnf", "[client]\nport = 3308\nuser = a\n")
+	s.write(c, "conf.d/ignored.txt", "[client]\nuser = ignored\n")
+	s.write(c, "secrets.cnf", "[client]\npassword = secret\n")
+	path := s.write(c, "my.cnf", "[client]\nhost = localhost\n!include secrets.cnf\n!includedir conf.d\nuser = root\n")
+
+	cfg, err := ReadOptionFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Host, Equals, "localhost")
+	c.Assert(cfg.Pass, Equals, "secret")
+	c.Assert(cfg.Port, Equals, 3309)
+	c.Assert(cfg.User, Equals, "root")
+
+	loop := s.write(c, "loop.cnf", "[client]\n!include loop.cnf\n")
+	_, err = ReadOptionFile(loop)
+	c.Assert(err, Equals, errIncludeDepth)
+}
+
+func (s *OptionFileSuite) TestErrors(c *C) {
+	_, err := ReadOptionFile(filepath.Join(s.dir, "missing.cnf"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = ReadOptionFile(s.write(c, "a.cnf", "user = root\n"))
+	c.Assert(err, ErrorMatches, ".*line 1: option outside of a group")
+
+	_, err = ReadOptionFile(s.write(c, "b.cnf", "[client\n"))
+	c.Assert(err, ErrorMatches, ".*line 1: \\[client")
+
+	_, err = ReadOptionFile(s.write(c, "c.cnf", "[client]\nport = http\n"))
+	c.Assert(err, ErrorMatches, "Invalid value for option port in .*: http")
+}
+
+func (s *OptionFileSuite) TestLoginPath(c *C) {
+	path := filepath.Join(s.dir, ".mylogin.cnf")
+	c.Assert(os.WriteFile(path, obfuscateLoginPath("[client]\n", "user = app\n", "password = secret\n"), 0600), IsNil)
+
+	cfg, err := ReadOptionFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.User, Equals, "app")
+	c.Assert(cfg.Pass, Equals, "secret")
+
+	c.Assert(os.WriteFile(path, append(make([]byte, 24), 5, 0, 0, 0, 1), 0600), IsNil)
+	_, err = ReadOptionFile(path)
+	c.Assert(err, Equals, errLoginPath)
+}
+
+// encodes lines the way mysql_config_editor does
+func obfuscateLoginPath(lines ...string) []byte {
+	key := []byte("0123456789abcdefghij")
+
+	var foldedKey [16]byte
+	for i, b := range key {
+		foldedKey[i%16] ^= b
+	}
+	block, _ := aes.NewCipher(foldedKey[:])
+
+	var out bytes.Buffer
+	out.Write(make([]byte, 4))
+	out.Write(key)
+
+	for _, line := range lines {
+		pad := aes.BlockSize - len(line)%aes.BlockSize
+		plain := append([]byte(line), bytes.Repeat([]byte{byte(pad)}, pad)...)
+
+		cipher := make([]byte, len(plain))
+		for i := 0; i < len(plain); i += aes.BlockSize {
+			block.Encrypt(cipher[i:i+aes.BlockSize], plain[i:i+aes.BlockSize])
+		}
+
+		binary.Write(&out, binary.LittleEndian, uint32(len(cipher)))
+		out.Write(cipher)
+	}
+
+	return out.Bytes()
+}
@@ -0,0 +1,92 @@
+// Package oteltracer records libmysql queries as OpenTelemetry spans:
+//
+//	connector, err := libmysql.NewConnector(dsn, nil)
+//	connector.SetTracer(oteltracer.New(otel.Tracer("libmysql")))
+//	db := sql.OpenDB(connector)
+//
+//	rows, err := db.QueryContext(ctx, "SELECT ...")
+package oteltracer
+
+import (
+	"context"
+	"strings"
+
+	"github.com/carlsverre/go-libmysql/libmysql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures the tracer returned by New
+type Option func(*tracer)
+
+// WithInterpolatedStatements records db.statement with its args interpolated.
+// By default the statement is recorded as passed to Exec or Query, so that
+// argument values do not reach the trace.
+func WithInterpolatedStatements() Option {
+	return func(t *tracer) {
+		t.interpolated = true
+	}
+}
+
+type tracer struct {
+	tracer       trace.Tracer
+	interpolated bool
+}
+
+// New returns a libmysql.Tracer which starts its spans with t
+func New(t trace.Tracer, opts ...Option) libmysql.Tracer {
+	out := &tracer{tracer: t}
+	for _, opt := range opts {
+		opt(out)
+	}
+	return out
+}
+
+func (t *tracer) Start(ctx context.Context, info *libmysql.SpanInfo) libmysql.Span {
+	statement := info.RawQuery
+	if t.interpolated {
+		statement = info.Query
+	}
+
+	_, s := t.tracer.Start(ctx, spanName(info),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mysql"),
+			attribute.String("db.statement", statement),
+			attribute.String("db.name", info.Database),
+			attribute.String("net.peer.name", info.Host),
+			attribute.Int("net.peer.port", info.Port),
+		),
+	)
+
+	return &span{span: s, op: info.Op}
+}
+
+// the first keyword of the query, such as SELECT
+func spanName(info *libmysql.SpanInfo) string {
+	if fields := strings.Fields(info.RawQuery); len(fields) > 0 {
+		return strings.ToUpper(fields[0])
+	}
+	return info.Op.String()
+}
+
+type span struct {
+	span trace.Span
+	op   libmysql.Op
+}
+
+func (s *span) End(err error, rows int64) {
+	if s.op == libmysql.OpExec {
+		s.span.SetAttributes(attribute.Int64("db.rows_affected", rows))
+	} else {
+		s.span.SetAttributes(attribute.Int64("db.response.returned_rows", rows))
+	}
+
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+
+	s.span.End()
+}
@@ -0,0 +1,123 @@
+package oteltracer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/carlsverre/go-libmysql/libmysql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	. "gopkg.in/check.v1"
+)
+
+type TracerSuite struct {
+	exporter *tracetest.InMemoryExporter
+	provider *sdktrace.TracerProvider
+	fake     *libmysql.Fake
+	db       *sql.DB
+}
+
+var _ = Suite(&TracerSuite{})
+
+func (s *TracerSuite) SetUpTest(c *C) {
+	s.exporter = tracetest.NewInMemoryExporter()
+	s.provider = sdktrace.NewTracerProvider(sdktrace.WithSyncer(s.exporter))
+	s.fake = libmysql.NewFake()
+}
+
+func (s *TracerSuite) open(c *C, opts ...Option) {
+	connector, err := libmysql.NewConnector("root@db.example.com:3307/app", s.fake.NewBackend)
+	c.Assert(err, IsNil)
+	connector.SetTracer(New(s.provider.Tracer("test"), opts...))
+
+	s.db = sql.OpenDB(connector)
+	s.db.SetMaxOpenConns(1)
+}
+
+func (s *TracerSuite) TearDownTest(c *C) {
+	s.db.Close()
+}
+
+func attrs(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	out := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes {
+		out[kv.Key] = kv.Value
+	}
+	return out
+}
+
+func (s *TracerSuite) TestExec(c *C) {
+	s.open(c)
+	s.fake.Expect(`^UPDATE users SET name = 'bob'$`).ReturnResult(2, 0)
+
+	ctx, parent := s.provider.Tracer("test").Start(context.Background(), "request")
+	_, err := s.db.ExecContext(ctx, "UPDATE users SET name = %s", "bob")
+	c.Assert(err, IsNil)
+	parent.End()
+
+	spans := s.exporter.GetSpans()
+	c.Assert(spans, HasLen, 2)
+
+	span := spans[0]
+	c.Assert(span.Name, Equals, "UPDATE")
+	c.Assert(span.Parent.SpanID(), Equals, parent.SpanContext().SpanID())
+	c.Assert(span.Status.Code, Equals, codes.Unset)
+
+	a := attrs(span)
+	c.Assert(a["db.system"].AsString(), Equals, "mysql")
+	c.Assert(a["db.statement"].AsString(), Equals, "UPDATE users SET name = %s")
+	c.Assert(a["db.name"].AsString(), Equals, "app")
+	c.Assert(a["net.peer.name"].AsString(), Equals, "db.example.com")
+	c.Assert(a["net.peer.port"].AsInt64(), Equals, int64(3307))
+	c.Assert(a["db.rows_affected"].AsInt64(), Equals, int64(2))
+}
+
+func (s *TracerSuite) TestQuery(c *C) {
+	s.open(c, WithInterpolatedStatements())
+	s.fake.Expect(`^SELECT`).ReturnRows([]string{"id"}, []interface{}{1}, []interface{}{2}, []interface{}{3})
+
+	rows, err := s.db.QueryContext(context.Background(), "SELECT id FROM users WHERE name = %s", "bob")
+	c.Assert(err, IsNil)
+
+	// the span covers reading the rows
+	c.Assert(s.exporter.GetSpans(), HasLen, 0)
+	for rows.Next() {
+	}
+	c.Assert(rows.Close(), IsNil)
+
+	spans := s.exporter.GetSpans()
+	c.Assert(spans, HasLen, 1)
+
+	a := attrs(spans[0])
+	c.Assert(a["db.statement"].AsString(), Equals, "SELECT id FROM users WHERE name = 'bob'")
+	c.Assert(a["db.response.returned_rows"].AsInt64(), Equals, int64(3))
+}
+
+func (s *TracerSuite) TestError(c *C) {
+	s.open(c)
+	s.fake.Expect(`^DELETE`).ReturnError(errors.New("failed"))
+
+	_, err := s.db.ExecContext(context.Background(), "DELETE FROM users")
+	c.Assert(err, ErrorMatches, "failed")
+
+	spans := s.exporter.GetSpans()
+	c.Assert(spans, HasLen, 1)
+	c.Assert(spans[0].Status.Code, Equals, codes.Error)
+	c.Assert(spans[0].Status.Description, Equals, "failed")
+	c.Assert(spans[0].Events, HasLen, 1)
+}
+
+func (s *TracerSuite) TestCanceled(c *C) {
+	s.open(c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM users")
+	c.Assert(errors.Is(err, context.Canceled), Equals, true)
+	c.Assert(s.exporter.GetSpans(), HasLen, 0)
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
@@ -0,0 +1,81 @@
+// Package promcollector exports the libmysql driver's metrics to Prometheus:
+//
+//	prometheus.MustRegister(promcollector.New())
+package promcollector
+
+import (
+	"strconv"
+
+	"github.com/carlsverre/go-libmysql/libmysql"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "libmysql"
+
+var (
+	queriesDesc = prometheus.NewDesc(namespace+"_queries_total",
+		"Queries run by the driver.", nil, nil)
+	execsDesc = prometheus.NewDesc(namespace+"_execs_total",
+		"Execs run by the driver.", nil, nil)
+	errorsDesc = prometheus.NewDesc(namespace+"_errors_total",
+		"Failed connects, queries and execs by MySQL error number, 0 for errors which did not come from MySQL.",
+		[]string{"errno"}, nil)
+	rowsDesc = prometheus.NewDesc(namespace+"_rows_fetched_total",
+		"Rows read from result sets.", nil, nil)
+	bytesDesc = prometheus.NewDesc(namespace+"_bytes_read_total",
+		"Bytes in the fields of rows read from result sets.", nil, nil)
+	connectDesc = prometheus.NewDesc(namespace+"_connect_duration_seconds",
+		"Time taken to connect to the server.", nil, nil)
+	queryDesc = prometheus.NewDesc(namespace+"_query_duration_seconds",
+		"Time taken by execs, and by queries until their first row is available.", nil, nil)
+	bridgesDesc = prometheus.NewDesc(namespace+"_live_bridges",
+		"Open libmysqlclient connections.", nil, nil)
+)
+
+// implements prometheus.Collector by reading libmysql.DriverStats
+type collector struct{}
+
+// New returns a collector for the metrics of every connection opened by the
+// driver
+func New() prometheus.Collector {
+	return collector{}
+}
+
+func (collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queriesDesc
+	ch <- execsDesc
+	ch <- errorsDesc
+	ch <- rowsDesc
+	ch <- bytesDesc
+	ch <- connectDesc
+	ch <- queryDesc
+	ch <- bridgesDesc
+}
+
+func (collector) Collect(ch chan<- prometheus.Metric) {
+	stats := libmysql.DriverStats()
+
+	ch <- prometheus.MustNewConstMetric(queriesDesc, prometheus.CounterValue, float64(stats.Queries))
+	ch <- prometheus.MustNewConstMetric(execsDesc, prometheus.CounterValue, float64(stats.Execs))
+	for errno, n := range stats.Errors {
+		ch <- prometheus.MustNewConstMetric(errorsDesc, prometheus.CounterValue, float64(n), strconv.Itoa(int(errno)))
+	}
+	ch <- prometheus.MustNewConstMetric(rowsDesc, prometheus.CounterValue, float64(stats.RowsFetched))
+	ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.CounterValue, float64(stats.BytesRead))
+	ch <- histogram(connectDesc, stats.ConnectLatency)
+	ch <- histogram(queryDesc, stats.QueryLatency)
+	ch <- prometheus.MustNewConstMetric(bridgesDesc, prometheus.GaugeValue, float64(stats.LiveBridges))
+}
+
+// Converts a snapshot to a prometheus histogram, which has cumulative buckets
+func histogram(desc *prometheus.Desc, h libmysql.Histogram) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(h.Bounds))
+
+	var count uint64
+	for i, bound := range h.Bounds {
+		count += h.Counts[i]
+		buckets[bound.Seconds()] = count
+	}
+
+	return prometheus.MustNewConstHistogram(desc, h.Count, h.Sum.Seconds(), buckets)
+}
@@ -0,0 +1,98 @@
+package promcollector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/carlsverre/go-libmysql/libmysql"
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	. "gopkg.in/check.v1"
+)
+
+type CollectorSuite struct{}
+
+var _ = Suite(&CollectorSuite{})
+
+// Gathers the registry's metrics by name
+func gather(c *C, reg *prometheus.Registry) map[string]*dto.MetricFamily {
+	families, err := reg.Gather()
+	c.Assert(err, IsNil)
+
+	out := make(map[string]*dto.MetricFamily)
+	for _, f := range families {
+		out[f.GetName()] = f
+	}
+	return out
+}
+
+func counter(f map[string]*dto.MetricFamily, name string) float64 {
+	if family, ok := f[name]; ok {
+		return family.GetMetric()[0].GetCounter().GetValue()
+	}
+	return 0
+}
+
+func errorCount(f map[string]*dto.MetricFamily, errno string) float64 {
+	for _, m := range f["libmysql_errors_total"].GetMetric() {
+		if m.GetLabel()[0].GetValue() == errno {
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func (s *CollectorSuite) TestCollect(c *C) {
+	reg := prometheus.NewRegistry()
+	c.Assert(reg.Register(New()), IsNil)
+
+	fake := libmysql.NewFake()
+	fake.Expect(`^SELECT`).ReturnRows([]string{"a"}, []interface{}{"abc"}, []interface{}{nil})
+	fake.Expect(`^INSERT`).ReturnResult(1, 1)
+	fake.Expect(`^DELETE`).ReturnError(&bridge.MySQLError{Errno: 1146, Message: "Table 'x' doesn't exist"})
+
+	connector, err := libmysql.NewConnector("root@localhost", fake.NewBackend)
+	c.Assert(err, IsNil)
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	before := gather(c, reg)
+
+	rows, err := db.QueryContext(context.Background(), "SELECT a FROM x")
+	c.Assert(err, IsNil)
+	for rows.Next() {
+	}
+	c.Assert(rows.Close(), IsNil)
+
+	_, err = db.Exec("INSERT INTO x VALUES (1)")
+	c.Assert(err, IsNil)
+
+	_, err = db.Exec("DELETE FROM x")
+	var myErr *bridge.MySQLError
+	c.Assert(errors.As(err, &myErr), Equals, true)
+
+	after := gather(c, reg)
+
+	delta := func(name string) float64 {
+		return counter(after, name) - counter(before, name)
+	}
+	c.Assert(delta("libmysql_queries_total"), Equals, float64(1))
+	c.Assert(delta("libmysql_execs_total"), Equals, float64(2))
+	c.Assert(delta("libmysql_rows_fetched_total"), Equals, float64(2))
+	c.Assert(delta("libmysql_bytes_read_total"), Equals, float64(3))
+	c.Assert(errorCount(after, "1146")-errorCount(before, "1146"), Equals, float64(1))
+
+	query := after["libmysql_query_duration_seconds"].GetMetric()[0].GetHistogram()
+	c.Assert(query.GetSampleCount() >= 3, Equals, true)
+	buckets := query.GetBucket()
+	c.Assert(buckets, HasLen, len(libmysql.DriverStats().QueryLatency.Bounds))
+	c.Assert(buckets[len(buckets)-1].GetCumulativeCount() <= query.GetSampleCount(), Equals, true)
+
+	connect := after["libmysql_connect_duration_seconds"].GetMetric()[0].GetHistogram()
+	c.Assert(connect.GetSampleCount() >= 1, Equals, true)
+
+	c.Assert(after["libmysql_live_bridges"].GetMetric()[0].GetGauge(), NotNil)
+}
@@ -0,0 +1,10 @@
+package promcollector
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// hook into gocheck
+func Test(t *testing.T) { TestingT(t) }
@@ -0,0 +1,316 @@
+package libmysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// clauses which make a SELECT lock or write
+	rWriteClause = regexp.MustCompile(`\b(FOR\s+UPDATE|FOR\s+SHARE|LOCK\s+IN\s+SHARE\s+MODE|INTO|INSERT|UPDATE|DELETE|REPLACE)\b`)
+
+	// EXPLAIN ANALYZE runs the statement it explains
+	rAnalyze = regexp.MustCompile(`^(FORMAT\s*=\s*\w+\s+)?ANALYZE\b`)
+
+	errNoLagColumn = errors.New("SHOW REPLICA STATUS returned no Seconds_Behind_Source column")
+)
+
+type routeKey struct{}
+
+type route int
+
+const (
+	routePrimary route = iota + 1
+	routeReplica
+)
+
+// WithPrimary returns a context which sends the statements of a ReplicaSet
+// run with it to the primary, such as reads which must see earlier writes
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, routeKey{}, routePrimary)
+}
+
+// WithReplica returns a context which sends the statements of a ReplicaSet
+// run with it to a replica, whatever they look like
+func WithReplica(ctx context.Context) context.Context {
+	return context.WithValue(ctx, routeKey{}, routeReplica)
+}
+
+// ReplicaSetOptions configures replica lag checking, which is disabled when
+// MaxLag is zero
+type ReplicaSetOptions struct {
+	// replicas which are further behind than MaxLag, or whose replication
+	// is stopped, are not used until a later check finds them caught up
+	MaxLag time.Duration
+	// how often lag is checked in the background, when zero it is only
+	// checked by calling CheckLag
+	LagCheckInterval time.Duration
+}
+
+// ReplicaSet sends writes and transactions to a primary, and read only
+// statements to its replicas in turn.  A statement is read only when it is a
+// SELECT which doesn't lock rows or write into anything, a SHOW, or a
+// DESCRIBE or EXPLAIN which doesn't ANALYZE (and so run) the statement;
+// WithPrimary and WithReplica override the classification.
+// Statements run in a transaction go to its connection on the primary.
+// Reads go to the primary when no replica is usable.
+type ReplicaSet struct {
+	primary  *sql.DB
+	replicas []*replica
+	opts     ReplicaSetOptions
+
+	next atomic.Uint64
+
+	stop      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+type replica struct {
+	db      *sql.DB
+	lagging atomic.Bool
+}
+
+// NewReplicaSet opens a ReplicaSet, see NewConnector.  opts may be nil.
+func NewReplicaSet(primary driver.Connector, replicas []driver.Connector, opts *ReplicaSetOptions) *ReplicaSet {
+	rs := &ReplicaSet{
+		primary: sql.OpenDB(primary),
+		stop:    make(chan struct{}),
+	}
+	if opts != nil {
+		rs.opts = *opts
+	}
+
+	for _, connector := range replicas {
+		rs.replicas = append(rs.replicas, &replica{db: sql.OpenDB(connector)})
+	}
+
+	if rs.opts.MaxLag > 0 && rs.opts.LagCheckInterval > 0 && len(rs.replicas) > 0 {
+		rs.wg.Add(1)
+		go rs.checkLagLoop()
+	}
+
+	return rs
+}
+
+// Primary returns the primary, such as for statements which must not be
+// classified
+func (rs *ReplicaSet) Primary() *sql.DB {
+	return rs.primary
+}
+
+// Replica returns the next usable replica, or the primary when there is none
+func (rs *ReplicaSet) Replica() *sql.DB {
+	n := uint64(len(rs.replicas))
+	start := rs.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		r := rs.replicas[(start+i)%n]
+		if !r.lagging.Load() {
+			return r.db
+		}
+	}
+	return rs.primary
+}
+
+func (rs *ReplicaSet) route(ctx context.Context, query string) *sql.DB {
+	switch ctx.Value(routeKey{}) {
+	case routePrimary:
+		return rs.primary
+	case routeReplica:
+		return rs.Replica()
+	}
+
+	if isReadOnly(query) {
+		return rs.Replica()
+	}
+	return rs.primary
+}
+
+func (rs *ReplicaSet) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return rs.ExecContext(context.Background(), query, args...)
+}
+
+func (rs *ReplicaSet) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return rs.route(ctx, query).ExecContext(ctx, query, args...)
+}
+
+func (rs *ReplicaSet) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return rs.QueryContext(context.Background(), query, args...)
+}
+
+func (rs *ReplicaSet) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return rs.route(ctx, query).QueryContext(ctx, query, args...)
+}
+
+func (rs *ReplicaSet) QueryRow(query string, args ...interface{}) *sql.Row {
+	return rs.QueryRowContext(context.Background(), query, args...)
+}
+
+func (rs *ReplicaSet) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return rs.route(ctx, query).QueryRowContext(ctx, query, args...)
+}
+
+// Begin starts a transaction on the primary
+func (rs *ReplicaSet) Begin() (*sql.Tx, error) {
+	return rs.BeginTx(context.Background(), nil)
+}
+
+// BeginTx starts a transaction on the primary
+func (rs *ReplicaSet) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return rs.primary.BeginTx(ctx, opts)
+}
+
+// Close stops checking lag and closes the primary and replicas
+func (rs *ReplicaSet) Close() error {
+	rs.closeOnce.Do(func() { close(rs.stop) })
+	rs.wg.Wait()
+
+	errs := []error{rs.primary.Close()}
+	for _, r := range rs.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// CheckLag checks how far behind each replica is with SHOW REPLICA STATUS.
+// Replicas which can't be checked aren't used until a later check succeeds.
+// It does nothing when MaxLag is zero.
+func (rs *ReplicaSet) CheckLag(ctx context.Context) error {
+	if rs.opts.MaxLag <= 0 {
+		return nil
+	}
+
+	var errs []error
+	for _, r := range rs.replicas {
+		lag, running, err := replicaLag(ctx, r.db)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		r.lagging.Store(err != nil || !running || lag > rs.opts.MaxLag)
+	}
+	return errors.Join(errs...)
+}
+
+func (rs *ReplicaSet) checkLagLoop() {
+	defer rs.wg.Done()
+
+	ticker := time.NewTicker(rs.opts.LagCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), rs.opts.LagCheckInterval)
+		rs.CheckLag(ctx)
+		cancel()
+
+		select {
+		case <-rs.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Returns the lag of the replica's furthest behind channel, and whether
+// replication is running.  A server which isn't a replica has no lag.
+func replicaLag(ctx context.Context, db *sql.DB) (lag time.Duration, running bool, err error) {
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, false, err
+	}
+
+	col := -1
+	for i, name := range cols {
+		if name == "Seconds_Behind_Source" || name == "Seconds_Behind_Master" {
+			col = i
+		}
+	}
+	if col < 0 {
+		return 0, false, errNoLagColumn
+	}
+
+	running = true
+	values := make([]sql.RawBytes, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return 0, false, err
+		}
+
+		// NULL while replication is stopped
+		if values[col] == nil {
+			running = false
+			continue
+		}
+
+		seconds, err := strconv.ParseInt(string(values[col]), 10, 64)
+		if err != nil {
+			return 0, false, err
+		}
+		lag = max(lag, time.Duration(seconds)*time.Second)
+	}
+
+	return lag, running, rows.Err()
+}
+
+// Whether the query only reads, so that it can run on a replica
+func isReadOnly(query string) bool {
+	query = strings.ToUpper(skipComments(query))
+	query = strings.TrimLeft(query, "( \t\r\n")
+
+	keyword := query
+	if end := strings.IndexFunc(query, func(r rune) bool { return r < 'A' || r > 'Z' }); end >= 0 {
+		keyword = query[:end]
+	}
+
+	switch keyword {
+	case "SELECT", "WITH":
+		return !rWriteClause.MatchString(query)
+	case "SHOW":
+		return true
+	case "DESCRIBE", "DESC", "EXPLAIN":
+		return !rAnalyze.MatchString(skipComments(query[len(keyword):]))
+	}
+	return false
+}
+
+// Strips the whitespace and comments which start the query
+func skipComments(query string) string {
+	for {
+		query = strings.TrimLeft(query, " \t\r\n")
+
+		switch {
+		case strings.HasPrefix(query, "/*"):
+			end := strings.Index(query, "*/")
+			if end < 0 {
+				return ""
+			}
+			query = query[end+2:]
+		case strings.HasPrefix(query, "--"), strings.HasPrefix(query, "#"):
+			end := strings.IndexByte(query, '\n')
+			if end < 0 {
+				return ""
+			}
+			query = query[end+1:]
+		default:
+			return query
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package libmysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ReplicaSetSuite struct {
+	primary  *Fake
+	replicas []*Fake
+	rs       *ReplicaSet
+}
+
+var _ = Suite(&ReplicaSetSuite{})
+
+func (s *ReplicaSetSuite) SetUpTest(c *C) {
+	s.primary = NewFake()
+	s.replicas = []*Fake{NewFake(), NewFake()}
+
+	primary, err := NewConnector("root@primary", s.primary.NewBackend)
+	c.Assert(err, IsNil)
+
+	var replicas []driver.Connector
+	for _, fake := range s.replicas {
+		connector, err := NewConnector("root@replica", fake.NewBackend)
+		c.Assert(err, IsNil)
+		replicas = append(replicas, connector)
+	}
+
+	s.rs = NewReplicaSet(primary, replicas, &ReplicaSetOptions{MaxLag: 10 * time.Second})
+}
+
+func (s *ReplicaSetSuite) TearDownTest(c *C) {
+	c.Assert(s.rs.Close(), IsNil)
+}
+
+func (s *ReplicaSetSuite) assertMet(c *C) {
+	c.Assert(s.primary.ExpectationsMet(), IsNil)
+	for _, fake := range s.replicas {
+		c.Assert(fake.ExpectationsMet(), IsNil)
+	}
+}
+
+func (s *ReplicaSetSuite) TestRouting(c *C) {
+	s.replicas[1].Expect(`^SELECT 1$`).ReturnRows([]string{"1"}, []interface{}{1})
+	s.replicas[0].Expect(`^/\* report \*/ SHOW TABLES$`).ReturnRows([]string{"Tables"})
+	s.primary.Expect(`^SELECT id FROM x FOR UPDATE$`).ReturnRows([]string{"id"})
+	s.primary.Expect(`^INSERT INTO x VALUES \(1\)$`).ReturnResult(1, 1)
+	s.primary.Expect(`^SELECT 2$`).ReturnRows([]string{"2"}, []interface{}{2})
+	s.replicas[1].Expect(`^CALL report\(\)$`)
+
+	var n int
+	c.Assert(s.rs.QueryRow("SELECT 1").Scan(&n), IsNil)
+
+	rows, err := s.rs.Query("/* report */ SHOW TABLES")
+	c.Assert(err, IsNil)
+	rows.Close()
+
+	rows, err = s.rs.Query("SELECT id FROM x FOR UPDATE")
+	c.Assert(err, IsNil)
+	rows.Close()
+
+	_, err = s.rs.Exec("INSERT INTO x VALUES (%s)", 1)
+	c.Assert(err, IsNil)
+
+	c.Assert(s.rs.QueryRowContext(WithPrimary(context.Background()), "SELECT 2").Scan(&n), IsNil)
+
+	_, err = s.rs.ExecContext(WithReplica(context.Background()), "CALL report()")
+	c.Assert(err, IsNil)
+
+	s.assertMet(c)
+}
+
+func (s *ReplicaSetSuite) TestTransactions(c *C) {
+	s.primary.Expect(`^START TRANSACTION$`)
+	s.primary.Expect(`^SELECT 1$`).ReturnRows([]string{"1"}, []interface{}{1})
+	s.primary.Expect(`^COMMIT$`)
+
+	tx, err := s.rs.Begin()
+	c.Assert(err, IsNil)
+
+	var n int
+	c.Assert(tx.QueryRow("SELECT 1").Scan(&n), IsNil)
+	c.Assert(tx.Commit(), IsNil)
+
+	s.assertMet(c)
+}
+
+func (s *ReplicaSetSuite) TestLag(c *C) {
+	s.replicas[0].Expect(`^SHOW REPLICA STATUS$`).ReturnRows([]string{"Seconds_Behind_Source"}, []interface{}{60})
+	s.replicas[1].Expect(`^SHOW REPLICA STATUS$`).ReturnRows([]string{"Seconds_Behind_Source"}, []interface{}{2})
+	c.Assert(s.rs.CheckLag(context.Background()), IsNil)
+
+	// only the replica which is caught up is used
+	for i := 0; i < 2; i++ {
+		s.replicas[1].Expect(`^SELECT 1$`).ReturnRows([]string{"1"}, []interface{}{1})
+
+		var n int
+		c.Assert(s.rs.QueryRow("SELECT 1").Scan(&n), IsNil)
+	}
+
+	// replication stopped, and a server which isn't a replica
+	s.replicas[0].Expect(`^SHOW REPLICA STATUS$`).ReturnRows([]string{"Seconds_Behind_Source"})
+	s.replicas[1].Expect(`^SHOW REPLICA STATUS$`).ReturnRows([]string{"Seconds_Behind_Master"}, []interface{}{nil})
+	c.Assert(s.rs.CheckLag(context.Background()), IsNil)
+
+	s.replicas[0].Expect(`^SELECT 1$`).ReturnRows([]string{"1"}, []interface{}{1})
+	var n int
+	c.Assert(s.rs.QueryRow("SELECT 1").Scan(&n), IsNil)
+
+	// every replica is unusable
+	s.replicas[0].Expect(`^SHOW REPLICA STATUS$`).ReturnRows([]string{"Seconds_Behind_Source"}, []interface{}{nil})
+	s.replicas[1].Expect(`^SHOW REPLICA STATUS$`).ReturnRows([]string{"Seconds_Behind_Source"}, []interface{}{nil})
+	c.Assert(s.rs.CheckLag(context.Background()), IsNil)
+
+	s.primary.Expect(`^SELECT 1$`).ReturnRows([]string{"1"}, []interface{}{1})
+	c.Assert(s.rs.QueryRow("SELECT 1").Scan(&n), IsNil)
+
+	s.assertMet(c)
+}
+
+func (s *ReplicaSetSuite) TestLagDisabled(c *C) {
+	primary, err := NewConnector("root@primary", s.primary.NewBackend)
+	c.Assert(err, IsNil)
+	replica, err := NewConnector("root@replica", s.replicas[0].NewBackend)
+	c.Assert(err, IsNil)
+
+	rs := NewReplicaSet(primary, []driver.Connector{replica}, &ReplicaSetOptions{})
+	defer rs.Close()
+
+	// nothing is checked, so the replica stays in use
+	c.Assert(rs.CheckLag(context.Background()), IsNil)
+
+	s.replicas[0].Expect(`^SELECT 1$`).ReturnRows([]string{"1"}, []interface{}{1})
+	var n int
+	c.Assert(rs.QueryRow("SELECT 1").Scan(&n), IsNil)
+
+	s.assertMet(c)
+}
+
+func (s *ReplicaSetSuite) TestIsReadOnly(c *C) {
+	readOnly := []string{
+		"SELECT 1",
+		"select\n*\nfrom x",
+		"(SELECT 1) UNION (SELECT 2)",
+		"-- comment\nSELECT 1",
+		"# comment\n/* another */ SHOW DATABASES",
+		"WITH t AS (SELECT 1) SELECT * FROM t",
+		"EXPLAIN SELECT 1",
+		"EXPLAIN FORMAT=JSON DELETE FROM x",
+		"DESC x",
+	}
+	for _, query := range readOnly {
+		c.Assert(isReadOnly(query), Equals, true, Commentf(query))
+	}
+
+	writes := []string{
+		"INSERT INTO x VALUES (1)",
+		"SELECT * FROM x FOR UPDATE",
+		"SELECT * FROM x LOCK IN SHARE MODE",
+		"SELECT 1 INTO @a",
+		"WITH t AS (SELECT 1) DELETE FROM x",
+		"EXPLAIN ANALYZE DELETE FROM x",
+		"explain /* plan */ analyze update x set a = 1",
+		"DESCRIBE FORMAT=TREE ANALYZE SELECT 1",
+		"SELECTED",
+		"/* unterminated SELECT 1",
+		"",
+	}
+	for _, query := range writes {
+		c.Assert(isReadOnly(query), Equals, false, Commentf(query))
+	}
+}
+
+func (s *ReplicaSetSuite) TestCloseTwice(c *C) {
+	primary, err := NewConnector("root@primary", s.primary.NewBackend)
+	c.Assert(err, IsNil)
+
+	rs := NewReplicaSet(primary, nil, &ReplicaSetOptions{MaxLag: time.Second, LagCheckInterval: time.Hour})
+	c.Assert(rs.Close(), IsNil)
+	c.Assert(rs.Close(), IsNil)
+}
@@ -0,0 +1,206 @@
+package libmysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultBaseDelay   = 50 * time.Millisecond
+	defaultMaxDelay    = time.Second
+)
+
+var (
+	// returned (wrapping the error) when the connection was lost during
+	// COMMIT, which may or may not have been applied so is not retried
+	ErrCommitUnknown = errors.New("Connection lost during COMMIT, the transaction may have been committed")
+
+	errNotReadOnly = errors.New("Only read only statements can be retried")
+
+	// errors after which the statement or transaction may succeed if run
+	// again.  A lock wait timeout only rolls back the statement, unless
+	// innodb_rollback_on_timeout is set, so RunInTx rolls back the rest.
+	retryableErrnos = map[uint16]bool{
+		bridge.ER_LOCK_DEADLOCK:     true,
+		bridge.ER_LOCK_WAIT_TIMEOUT: true,
+		bridge.ER_SERVER_SHUTDOWN:   true,
+		2006:                        true, // CR_SERVER_GONE_ERROR
+		2013:                        true, // CR_SERVER_LOST
+	}
+)
+
+// TxBeginner is implemented by *sql.DB, *sql.Conn and *ReplicaSet
+type TxBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// Queryer is implemented by *sql.DB, *sql.Conn, *sql.Tx and *ReplicaSet
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// RetryOptions configures RunInTx and RetryQuery, the zero value uses the
+// defaults
+type RetryOptions struct {
+	// the options of each transaction started by RunInTx
+	TxOptions *sql.TxOptions
+
+	// the number of attempts, including the first
+	MaxAttempts int
+	// the delay before the first retry, which doubles for every later one
+	// up to MaxDelay.  Each delay is randomly shortened by up to half.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// decides which errors are retried, IsRetryable when nil
+	Retryable func(err error) bool
+}
+
+func (opts *RetryOptions) withDefaults() RetryOptions {
+	o := RetryOptions{}
+	if opts != nil {
+		o = *opts
+	}
+
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = defaultMaxAttempts
+	}
+	if o.BaseDelay <= 0 {
+		o.BaseDelay = defaultBaseDelay
+	}
+	if o.MaxDelay <= 0 {
+		o.MaxDelay = defaultMaxDelay
+	}
+	if o.Retryable == nil {
+		o.Retryable = IsRetryable
+	}
+	return o
+}
+
+// Waits before the retry following the attempt, returns the context's error
+// if it is done first
+func (opts *RetryOptions) wait(ctx context.Context, attempt int) error {
+	delay := opts.BaseDelay << min(attempt-1, 30)
+	if delay <= 0 || delay > opts.MaxDelay {
+		delay = opts.MaxDelay
+	}
+	delay -= time.Duration(rand.Int64N(int64(delay)/2 + 1))
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// IsRetryable reports whether the error is transient, such as a deadlock, a
+// lock wait timeout or a lost connection
+func IsRetryable(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	var myErr *bridge.MySQLError
+	return errors.As(err, &myErr) && retryableErrnos[myErr.Errno]
+}
+
+// RunInTx runs fn in a transaction which is committed when fn returns nil and
+// rolled back otherwise.  The whole transaction is run again when it fails
+// with a retryable error, so fn must not have side effects outside of the
+// transaction.  A connection lost during COMMIT is never retried, as the
+// transaction may have been committed; ErrCommitUnknown is returned instead.
+//
+//	err := libmysql.RunInTx(ctx, db, nil, func(tx *sql.Tx) error {
+//		_, err := tx.Exec("UPDATE accounts SET balance = balance - %s WHERE id = %s", 10, 1)
+//		return err
+//	})
+func RunInTx(ctx context.Context, db TxBeginner, opts *RetryOptions, fn func(tx *sql.Tx) error) error {
+	o := opts.withDefaults()
+
+	for attempt := 1; ; attempt++ {
+		err := runTx(ctx, db, o.TxOptions, fn)
+		if err == nil || errors.Is(err, ErrCommitUnknown) {
+			return err
+		}
+
+		if attempt >= o.MaxAttempts || !o.Retryable(err) {
+			return err
+		}
+		if waitErr := o.wait(ctx, attempt); waitErr != nil {
+			return err
+		}
+	}
+}
+
+func runTx(ctx context.Context, db TxBeginner, opts *sql.TxOptions, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	committed = true
+	if err = tx.Commit(); err != nil && isConnLost(err) {
+		return fmt.Errorf("%w: %w", ErrCommitUnknown, err)
+	}
+	return err
+}
+
+// whether the connection was lost, so that the outcome of the statement is
+// unknown
+func isConnLost(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	var myErr *bridge.MySQLError
+	return errors.As(err, &myErr) && lostErrnos[myErr.Errno]
+}
+
+// RetryQuery runs a read only query, running it again when it fails with a
+// retryable error.  Errors while reading the rows are not retried.  Queries
+// which aren't read only (see ReplicaSet) are rejected, as running them
+// again may apply them twice.
+func RetryQuery(ctx context.Context, db Queryer, opts *RetryOptions, query string, args ...interface{}) (*sql.Rows, error) {
+	if !isReadOnly(query) {
+		return nil, errNotReadOnly
+	}
+
+	o := opts.withDefaults()
+
+	for attempt := 1; ; attempt++ {
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err == nil {
+			return rows, nil
+		}
+
+		if attempt >= o.MaxAttempts || !o.Retryable(err) {
+			return nil, err
+		}
+		if waitErr := o.wait(ctx, attempt); waitErr != nil {
+			return nil, err
+		}
+	}
+}
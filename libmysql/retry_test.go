@@ -0,0 +1,184 @@
+package libmysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
+	. "gopkg.in/check.v1"
+)
+
+type RetrySuite struct {
+	fake *Fake
+	db   *sql.DB
+	opts *RetryOptions
+
+	// the number of connections opened
+	conns int
+}
+
+var _ = Suite(&RetrySuite{})
+
+var (
+	errDeadlock = &bridge.MySQLError{Errno: 1213, Message: "Deadlock found when trying to get lock"}
+	errLost     = &bridge.MySQLError{Errno: 2013, Message: "Lost connection to MySQL server during query"}
+	errGone     = &bridge.MySQLError{Errno: 2006, Message: "MySQL server has gone away"}
+)
+
+func (s *RetrySuite) SetUpTest(c *C) {
+	s.fake = NewFake()
+
+	s.conns = 0
+	connector, err := NewConnector("root@localhost", func() Backend {
+		s.conns++
+		return s.fake.NewBackend()
+	})
+	c.Assert(err, IsNil)
+
+	s.db = sql.OpenDB(connector)
+	s.db.SetMaxOpenConns(1)
+	s.opts = &RetryOptions{BaseDelay: time.Millisecond}
+}
+
+func (s *RetrySuite) TearDownTest(c *C) {
+	s.db.Close()
+}
+
+func (s *RetrySuite) insert(tx *sql.Tx) error {
+	_, err := tx.Exec("INSERT INTO x VALUES (1)")
+	return err
+}
+
+func (s *RetrySuite) TestRunInTx(c *C) {
+	s.fake.Expect(`^START TRANSACTION$`)
+	s.fake.Expect(`^INSERT`).ReturnError(errDeadlock)
+	s.fake.Expect(`^ROLLBACK$`)
+	s.fake.Expect(`^START TRANSACTION$`)
+	s.fake.Expect(`^INSERT`)
+	s.fake.Expect(`^COMMIT$`)
+
+	attempts := 0
+	err := RunInTx(context.Background(), s.db, s.opts, func(tx *sql.Tx) error {
+		attempts++
+		return s.insert(tx)
+	})
+	c.Assert(err, IsNil)
+	c.Assert(attempts, Equals, 2)
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *RetrySuite) TestConnectionLost(c *C) {
+	// the lost connection is discarded, so nothing is rolled back on it and
+	// the transaction runs again on a new one
+	s.fake.Expect(`^START TRANSACTION$`)
+	s.fake.Expect(`^INSERT`).ReturnError(errLost)
+	s.fake.Expect(`^START TRANSACTION$`)
+	s.fake.Expect(`^INSERT`)
+	s.fake.Expect(`^COMMIT$`)
+
+	c.Assert(RunInTx(context.Background(), s.db, s.opts, s.insert), IsNil)
+	c.Assert(s.conns, Equals, 2)
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *RetrySuite) TestGoneAway(c *C) {
+	// the statement wasn't sent, so database/sql runs it on a new connection
+	s.fake.Expect(`^INSERT`).ReturnError(errGone)
+	s.fake.Expect(`^INSERT`)
+
+	_, err := s.db.Exec("INSERT INTO x VALUES (1)")
+	c.Assert(err, IsNil)
+	c.Assert(s.conns, Equals, 2)
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *RetrySuite) TestMaxAttempts(c *C) {
+	for i := 0; i < 3; i++ {
+		s.fake.Expect(`^START TRANSACTION$`)
+		s.fake.Expect(`^INSERT`).ReturnError(errDeadlock)
+		s.fake.Expect(`^ROLLBACK$`)
+	}
+
+	err := RunInTx(context.Background(), s.db, s.opts, s.insert)
+	c.Assert(errors.Is(err, errDeadlock), Equals, true)
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *RetrySuite) TestNotRetryable(c *C) {
+	failed := errors.New("insufficient funds")
+
+	s.fake.Expect(`^START TRANSACTION$`)
+	s.fake.Expect(`^ROLLBACK$`)
+
+	err := RunInTx(context.Background(), s.db, s.opts, func(tx *sql.Tx) error {
+		return failed
+	})
+	c.Assert(err, Equals, failed)
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *RetrySuite) TestCommit(c *C) {
+	// the server rolled back, so the transaction can run again
+	s.fake.Expect(`^START TRANSACTION$`)
+	s.fake.Expect(`^INSERT`)
+	s.fake.Expect(`^COMMIT$`).ReturnError(errDeadlock)
+	s.fake.Expect(`^START TRANSACTION$`)
+	s.fake.Expect(`^INSERT`)
+	s.fake.Expect(`^COMMIT$`)
+
+	c.Assert(RunInTx(context.Background(), s.db, s.opts, s.insert), IsNil)
+
+	// the commit may have been applied
+	s.fake.Expect(`^START TRANSACTION$`)
+	s.fake.Expect(`^INSERT`)
+	s.fake.Expect(`^COMMIT$`).ReturnError(errLost)
+
+	err := RunInTx(context.Background(), s.db, s.opts, s.insert)
+	c.Assert(errors.Is(err, ErrCommitUnknown), Equals, true)
+	c.Assert(errors.Is(err, errLost), Equals, true)
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *RetrySuite) TestCanceled(c *C) {
+	s.fake.Expect(`^START TRANSACTION$`)
+	s.fake.Expect(`^INSERT`).ReturnError(errDeadlock)
+	s.fake.Expect(`^ROLLBACK$`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	opts := &RetryOptions{BaseDelay: time.Hour}
+
+	err := RunInTx(ctx, s.db, opts, func(tx *sql.Tx) error {
+		cancel()
+		return s.insert(tx)
+	})
+	c.Assert(errors.Is(err, errDeadlock), Equals, true)
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *RetrySuite) TestRetryQuery(c *C) {
+	s.fake.Expect(`^SELECT 1$`).ReturnError(errLost)
+	s.fake.Expect(`^SELECT 1$`).ReturnRows([]string{"1"}, []interface{}{1})
+
+	rows, err := RetryQuery(context.Background(), s.db, s.opts, "SELECT 1")
+	c.Assert(err, IsNil)
+	c.Assert(rows.Close(), IsNil)
+	c.Assert(s.conns, Equals, 2)
+
+	_, err = RetryQuery(context.Background(), s.db, s.opts, "DELETE FROM x")
+	c.Assert(err, Equals, errNotReadOnly)
+
+	s.fake.Expect(`^SELECT 1$`).ReturnError(errDeadlock)
+	_, err = RetryQuery(context.Background(), s.db, &RetryOptions{MaxAttempts: 1}, "SELECT 1")
+	c.Assert(err, Equals, errDeadlock)
+
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *RetrySuite) TestIsRetryable(c *C) {
+	c.Assert(IsRetryable(errDeadlock), Equals, true)
+	c.Assert(IsRetryable(errLost), Equals, true)
+	c.Assert(IsRetryable(&bridge.MySQLError{Errno: 1062, Message: "Duplicate entry"}), Equals, false)
+	c.Assert(IsRetryable(errors.New("failed")), Equals, false)
+}
@@ -0,0 +1,171 @@
+package libmysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/carlsverre/go-libmysql/libmysql/escape"
+)
+
+// NestedTx wraps a transaction so that it can be passed to code which starts
+// transactions of its own.  Begin starts a nested transaction, which is a
+// savepoint in the outermost one: committing it releases the savepoint, and
+// rolling it back undoes only the statements run since it began.  Nothing is
+// committed until the outermost transaction is.
+//
+//	func transfer(ctx context.Context, parent *libmysql.NestedTx) error {
+//		return parent.Run(ctx, func(tx *libmysql.NestedTx) error {
+//			_, err := tx.ExecContext(ctx, "UPDATE accounts SET balance = balance - %s WHERE id = %s", 10, 1)
+//			return err
+//		})
+//	}
+type NestedTx struct {
+	*sql.Tx
+
+	parent *NestedTx
+	// the savepoint of a nested transaction
+	name string
+	done bool
+
+	// the number of nested transactions started, shared with the outermost
+	// transaction so that their savepoint names are unique
+	count *int
+}
+
+// Nested wraps tx, the outermost transaction
+func Nested(tx *sql.Tx) *NestedTx {
+	return &NestedTx{Tx: tx, count: new(int)}
+}
+
+// Depth returns how deeply the transaction is nested, zero for the outermost
+func (t *NestedTx) Depth() int {
+	depth := 0
+	for p := t.parent; p != nil; p = p.parent {
+		depth++
+	}
+	return depth
+}
+
+// whether the transaction, or one it is nested in, has been committed or
+// rolled back
+func (t *NestedTx) finished() bool {
+	for p := t; p != nil; p = p.parent {
+		if p.done {
+			return true
+		}
+	}
+	return false
+}
+
+// Begin starts a transaction nested in this one
+func (t *NestedTx) Begin(ctx context.Context) (*NestedTx, error) {
+	if t.finished() {
+		return nil, sql.ErrTxDone
+	}
+
+	*t.count++
+	name := fmt.Sprintf("libmysql_nested_%d", *t.count)
+	if err := t.Savepoint(ctx, name); err != nil {
+		return nil, err
+	}
+
+	return &NestedTx{Tx: t.Tx, parent: t, name: name, count: t.count}, nil
+}
+
+// Commit commits the outermost transaction, or releases the savepoint of a
+// nested one
+func (t *NestedTx) Commit() error {
+	if t.parent == nil {
+		t.done = true
+		return t.Tx.Commit()
+	}
+
+	if t.finished() {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	return t.Release(context.Background(), t.name)
+}
+
+// Rollback rolls back the outermost transaction, or the statements run since
+// a nested one began
+func (t *NestedTx) Rollback() error {
+	if t.parent == nil {
+		t.done = true
+		return t.Tx.Rollback()
+	}
+
+	if t.finished() {
+		return sql.ErrTxDone
+	}
+	t.done = true
+
+	ctx := context.Background()
+	if err := t.RollbackTo(ctx, t.name); err != nil {
+		return err
+	}
+	return t.Release(ctx, t.name)
+}
+
+// Run runs fn in a nested transaction, which is committed when fn returns nil
+// and rolled back otherwise
+func (t *NestedTx) Run(ctx context.Context, fn func(tx *NestedTx) error) (err error) {
+	nested, err := t.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			nested.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(nested); err != nil {
+		nested.Rollback()
+		return err
+	}
+	return nested.Commit()
+}
+
+// Savepoint sets a savepoint with the name, replacing any with the same name
+func (t *NestedTx) Savepoint(ctx context.Context, name string) error {
+	return Savepoint(ctx, t.Tx, name)
+}
+
+// RollbackTo undoes the statements run since the savepoint was set
+func (t *NestedTx) RollbackTo(ctx context.Context, name string) error {
+	return RollbackTo(ctx, t.Tx, name)
+}
+
+// Release removes the savepoint, keeping the statements run since it was set
+func (t *NestedTx) Release(ctx context.Context, name string) error {
+	return Release(ctx, t.Tx, name)
+}
+
+// Savepoint sets a savepoint with the name in the transaction, replacing any
+// with the same name
+func Savepoint(ctx context.Context, tx *sql.Tx, name string) error {
+	return execSavepoint(ctx, tx, "SAVEPOINT ", name)
+}
+
+// RollbackTo undoes the statements run in the transaction since the savepoint
+// was set, and removes the savepoints set after it
+func RollbackTo(ctx context.Context, tx *sql.Tx, name string) error {
+	return execSavepoint(ctx, tx, "ROLLBACK TO SAVEPOINT ", name)
+}
+
+// Release removes the savepoint, and those set after it, from the transaction
+func Release(ctx context.Context, tx *sql.Tx, name string) error {
+	return execSavepoint(ctx, tx, "RELEASE SAVEPOINT ", name)
+}
+
+func execSavepoint(ctx context.Context, tx *sql.Tx, stmt, name string) error {
+	// the identifier must survive the query's %s formatting
+	ident := strings.ReplaceAll(escape.EscapeIdentifier(name), "%", "%%")
+	_, err := tx.ExecContext(ctx, stmt+ident)
+	return err
+}
@@ -0,0 +1,113 @@
+package libmysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	. "gopkg.in/check.v1"
+)
+
+type SavepointSuite struct {
+	fake *Fake
+	db   *sql.DB
+}
+
+var _ = Suite(&SavepointSuite{})
+
+func (s *SavepointSuite) SetUpTest(c *C) {
+	s.fake = NewFake()
+
+	connector, err := NewConnector("root@localhost", s.fake.NewBackend)
+	c.Assert(err, IsNil)
+	s.db = sql.OpenDB(connector)
+}
+
+func (s *SavepointSuite) TearDownTest(c *C) {
+	s.db.Close()
+}
+
+func (s *SavepointSuite) TestSavepoints(c *C) {
+	ctx := context.Background()
+
+	s.fake.Expect(`^START TRANSACTION$`)
+	s.fake.Expect("^SAVEPOINT `before`$")
+	s.fake.Expect("^ROLLBACK TO SAVEPOINT `before`$")
+	s.fake.Expect("^RELEASE SAVEPOINT `before`$")
+	s.fake.Expect("^SAVEPOINT `100% ``done```$")
+	s.fake.Expect(`^COMMIT$`)
+
+	tx, err := s.db.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(Savepoint(ctx, tx, "before"), IsNil)
+	c.Assert(RollbackTo(ctx, tx, "before"), IsNil)
+	c.Assert(Release(ctx, tx, "before"), IsNil)
+	c.Assert(Savepoint(ctx, tx, "100% `done`"), IsNil)
+	c.Assert(tx.Commit(), IsNil)
+
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *SavepointSuite) TestNested(c *C) {
+	ctx := context.Background()
+	failed := errors.New("failed")
+
+	s.fake.Expect(`^START TRANSACTION$`)
+	s.fake.Expect("^SAVEPOINT `libmysql_nested_1`$")
+	s.fake.Expect(`^INSERT INTO x VALUES \(1\)$`)
+	s.fake.Expect("^SAVEPOINT `libmysql_nested_2`$")
+	s.fake.Expect(`^INSERT INTO x VALUES \(2\)$`)
+	s.fake.Expect("^ROLLBACK TO SAVEPOINT `libmysql_nested_2`$")
+	s.fake.Expect("^RELEASE SAVEPOINT `libmysql_nested_2`$")
+	s.fake.Expect("^RELEASE SAVEPOINT `libmysql_nested_1`$")
+	s.fake.Expect(`^COMMIT$`)
+
+	sqlTx, err := s.db.Begin()
+	c.Assert(err, IsNil)
+	tx := Nested(sqlTx)
+
+	err = tx.Run(ctx, func(inner *NestedTx) error {
+		c.Assert(inner.Depth(), Equals, 1)
+		if _, err := inner.Exec("INSERT INTO x VALUES (%s)", 1); err != nil {
+			return err
+		}
+
+		err := inner.Run(ctx, func(innermost *NestedTx) error {
+			c.Assert(innermost.Depth(), Equals, 2)
+			if _, err := innermost.Exec("INSERT INTO x VALUES (%s)", 2); err != nil {
+				return err
+			}
+			return failed
+		})
+		c.Assert(err, Equals, failed)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(tx.Commit(), IsNil)
+
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *SavepointSuite) TestDone(c *C) {
+	ctx := context.Background()
+
+	s.fake.Expect(`^START TRANSACTION$`)
+	s.fake.Expect("^SAVEPOINT `libmysql_nested_1`$")
+	s.fake.Expect(`^ROLLBACK$`)
+
+	sqlTx, err := s.db.Begin()
+	c.Assert(err, IsNil)
+	tx := Nested(sqlTx)
+
+	inner, err := tx.Begin(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(tx.Rollback(), IsNil)
+
+	c.Assert(inner.Commit(), Equals, sql.ErrTxDone)
+	c.Assert(inner.Rollback(), Equals, sql.ErrTxDone)
+	_, err = inner.Begin(ctx)
+	c.Assert(err, Equals, sql.ErrTxDone)
+	c.Assert(tx.Commit(), Equals, sql.ErrTxDone)
+
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
@@ -0,0 +1,388 @@
+package libmysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/carlsverre/go-libmysql/libmysql/bridge"
+	"github.com/carlsverre/go-libmysql/libmysql/testserver"
+	. "gopkg.in/check.v1"
+)
+
+// runs the real driver against the in-process test server
+type ServerSuite struct {
+	srv *testserver.Server
+	db  *sql.DB
+}
+
+var _ = Suite(&ServerSuite{})
+
+func (s *ServerSuite) SetUpTest(c *C) {
+	var err error
+
+	s.srv, err = testserver.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	s.db, err = sql.Open("libmysql", s.srv.DSN("root", ""))
+	c.Assert(err, IsNil)
+}
+
+func (s *ServerSuite) TearDownTest(c *C) {
+	s.db.Close()
+	s.srv.Close()
+}
+
+func (s *ServerSuite) TestStreaming(c *C) {
+	var rows [][]interface{}
+	for i := 0; i < 1000; i++ {
+		rows = append(rows, []interface{}{i, fmt.Sprintf("row %d", i)})
+	}
+	s.srv.Handle(`^SELECT id, name FROM x$`, testserver.Rows([]string{"id", "name"}, rows...))
+
+	res, err := s.db.Query("SELECT id, name FROM x")
+	c.Assert(err, IsNil)
+	defer res.Close()
+
+	count := 0
+	for res.Next() {
+		var id int
+		var name string
+		c.Assert(res.Scan(&id, &name), IsNil)
+		c.Assert(id, Equals, count)
+		c.Assert(name, Equals, fmt.Sprintf("row %d", count))
+		count++
+	}
+	c.Assert(res.Err(), IsNil)
+	c.Assert(count, Equals, 1000)
+}
+
+func (s *ServerSuite) TestClosedEarly(c *C) {
+	var rows [][]interface{}
+	for i := 0; i < 100; i++ {
+		rows = append(rows, []interface{}{i, fmt.Sprintf("row %d", i), i * 2})
+	}
+	s.srv.Handle(`^SELECT a, b, c FROM x$`, testserver.Rows([]string{"a", "b", "c"}, rows...))
+	s.srv.Handle(`^SELECT name FROM y$`, testserver.Rows([]string{"name"}, []interface{}{"only"}))
+
+	conn, err := s.db.Conn(context.Background())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	// the rest of the first batch must not leak into the next result
+	res, err := conn.QueryContext(context.Background(), "SELECT a, b, c FROM x")
+	c.Assert(err, IsNil)
+	c.Assert(res.Next(), Equals, true)
+	c.Assert(res.Close(), IsNil)
+
+	res, err = conn.QueryContext(context.Background(), "SELECT name FROM y")
+	c.Assert(err, IsNil)
+	defer res.Close()
+
+	var names []string
+	for res.Next() {
+		var name string
+		c.Assert(res.Scan(&name), IsNil)
+		names = append(names, name)
+	}
+	c.Assert(res.Err(), IsNil)
+	c.Assert(names, DeepEquals, []string{"only"})
+}
+
+func (s *ServerSuite) TestNulls(c *C) {
+	s.srv.Handle(`^SELECT`, testserver.Rows([]string{"a", "b"},
+		[]interface{}{nil, "x"},
+		[]interface{}{"", nil},
+	))
+
+	res, err := s.db.Query("SELECT a, b FROM x")
+	c.Assert(err, IsNil)
+	defer res.Close()
+
+	var got [][2]sql.NullString
+	for res.Next() {
+		var row [2]sql.NullString
+		c.Assert(res.Scan(&row[0], &row[1]), IsNil)
+		got = append(got, row)
+	}
+	c.Assert(res.Err(), IsNil)
+
+	c.Assert(got, DeepEquals, [][2]sql.NullString{
+		{{}, {String: "x", Valid: true}},
+		{{String: "", Valid: true}, {}},
+	})
+}
+
+func (s *ServerSuite) TestExec(c *C) {
+	s.srv.Handle(`^INSERT INTO x VALUES \('it\\'s'\)$`, testserver.Exec(1, 99))
+
+	res, err := s.db.Exec("INSERT INTO x VALUES (%s)", "it's")
+	c.Assert(err, IsNil)
+
+	affected, err := res.RowsAffected()
+	c.Assert(err, IsNil)
+	c.Assert(affected, Equals, int64(1))
+
+	id, err := res.LastInsertId()
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, int64(99))
+}
+
+func (s *ServerSuite) TestError(c *C) {
+	s.srv.Handle(`^SELECT`, testserver.Fail(1146, "Table 'x' doesn't exist"))
+
+	_, err := s.db.Query("SELECT * FROM x")
+
+	var myErr *bridge.MySQLError
+	c.Assert(errors.As(err, &myErr), Equals, true)
+	c.Assert(myErr.Errno, Equals, uint16(1146))
+	c.Assert(myErr.Message, Equals, "Table 'x' doesn't exist")
+}
+
+func (s *ServerSuite) TestDisconnect(c *C) {
+	s.srv.Handle(`^SELECT 1$`, testserver.Rows([]string{"1"}, []interface{}{1}))
+	s.srv.Handle(`^KILL$`, testserver.Disconnect())
+
+	conn, err := NewConn(s.srv.DSN("root", ""))
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	_, err = conn.Exec("KILL", nil)
+	assertServerLost(c, err)
+
+	// a dropped connection is not reconnected
+	_, err = conn.Exec("SELECT 1", nil)
+	c.Assert(err, NotNil)
+}
+
+func (s *ServerSuite) TestDropConnections(c *C) {
+	s.srv.Handle(`^SELECT 1$`, testserver.Rows([]string{"1"}, []interface{}{1}))
+
+	conn, err := NewConn(s.srv.DSN("root", ""))
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	_, err = conn.Exec("SELECT 1", nil)
+	c.Assert(err, IsNil)
+
+	s.srv.DropConnections()
+
+	_, err = conn.Exec("SELECT 1", nil)
+	assertServerLost(c, err)
+}
+
+func (s *ServerSuite) TestAuthentication(c *C) {
+	s.srv.AddUser("app", "secret")
+	s.srv.Handle(`^SELECT DATABASE\(\)$`, func(q *testserver.Query) (*testserver.Result, error) {
+		return &testserver.Result{
+			Columns: []string{"DATABASE()"},
+			Rows:    [][]interface{}{{q.Session.Database}},
+		}, nil
+	})
+
+	conn, err := NewConn(s.srv.DSN("app", "secret") + "/app_db")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	rows, err := conn.QueryBuffered("SELECT DATABASE()", nil)
+	c.Assert(err, IsNil)
+	defer rows.Close()
+
+	dest := make([]driver.Value, 1)
+	c.Assert(rows.Next(dest), IsNil)
+	c.Assert(dest[0], DeepEquals, []byte("app_db"))
+
+	_, err = NewConn(s.srv.DSN("app", "wrong"))
+
+	var myErr *bridge.MySQLError
+	c.Assert(errors.As(err, &myErr), Equals, true)
+	c.Assert(myErr.Errno, Equals, uint16(1045))
+
+	var authErr *AuthError
+	c.Assert(errors.As(err, &authErr), Equals, true)
+}
+
+func (s *ServerSuite) TestConnectAttrs(c *C) {
+	attrs := make(chan map[string]string, 1)
+	s.srv.Handle(`^SELECT 1$`, func(q *testserver.Query) (*testserver.Result, error) {
+		attrs <- q.Session.Attrs
+		return &testserver.Result{Columns: []string{"1"}, Rows: [][]interface{}{{1}}}, nil
+	})
+
+	conn, err := NewConn(s.srv.DSN("root", "") + "?connectAttrs=service:billing,program_name:api")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	_, err = conn.Exec("SELECT 1", nil)
+	c.Assert(err, IsNil)
+
+	got := <-attrs
+	c.Assert(got["service"], Equals, "billing")
+	c.Assert(got["program_name"], Equals, "api")
+	c.Assert(got["driver_name"], Equals, "go-libmysql")
+	c.Assert(got["go_version"], Equals, runtime.Version())
+}
+
+func (s *ServerSuite) TestCharset(c *C) {
+	s.srv.Handle(`^SET NAMES utf8mb4 COLLATE utf8mb4_bin$`, testserver.Exec(0, 0))
+
+	conn, err := NewConn(s.srv.DSN("root", "") + "?charset=utf8mb4&collation=utf8mb4_bin")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	c.Assert(conn.Charset(), Equals, "utf8mb4")
+
+	s.srv.Handle(`^SET NAMES latin7$`, testserver.Fail(1115, "Unknown character set: 'latin7'"))
+
+	_, err = NewConn(s.srv.DSN("root", "") + "?charset=latin7")
+
+	var myErr *bridge.MySQLError
+	c.Assert(errors.As(err, &myErr), Equals, true)
+	c.Assert(myErr.Errno, Equals, uint16(1115))
+}
+
+func (s *ServerSuite) TestSessionParams(c *C) {
+	sets := make(chan string, 2)
+	s.srv.Handle(`^SET `, func(q *testserver.Query) (*testserver.Result, error) {
+		sets <- q.SQL
+		return nil, nil
+	})
+
+	conn, err := NewConn(s.srv.DSN("root", "") + "?time_zone=%2B00:00&innodb_lock_wait_timeout=5&resetSession=true")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	c.Assert(conn.ResetSession(context.Background()), IsNil)
+
+	for i := 0; i < 2; i++ {
+		c.Assert(<-sets, Equals, "SET innodb_lock_wait_timeout = 5, time_zone = '+00:00'")
+	}
+}
+
+func (s *ServerSuite) TestInitCommand(c *C) {
+	s.srv.Handle(`^SET ROLE app$`, testserver.Exec(0, 0))
+
+	conn, err := NewConn(s.srv.DSN("root", "") + "?initCommand=SET%20ROLE%20app")
+	c.Assert(err, IsNil)
+	conn.Close()
+
+	s.srv.Handle(`^SET ROLE missing$`, testserver.Fail(3530, "`missing`@`%` is not granted"))
+
+	_, err = NewConn(s.srv.DSN("root", "") + "?initCommand=SET%20ROLE%20missing")
+
+	var myErr *bridge.MySQLError
+	c.Assert(errors.As(err, &myErr), Equals, true)
+	c.Assert(myErr.Errno, Equals, uint16(3530))
+}
+
+func (s *ServerSuite) TestRotatingCredentials(c *C) {
+	path := filepath.Join(c.MkDir(), "password")
+	c.Assert(os.WriteFile(path, []byte("first\n"), 0600), IsNil)
+	s.srv.AddUser("app", "first")
+
+	cfg, err := ParseDSN(s.srv.DSN("root", ""))
+	c.Assert(err, IsNil)
+	cfg.Credentials = &FileCredentials{User: "app", PasswordFile: path}
+
+	db := sql.OpenDB(NewConnectorConfig(cfg, nil))
+	defer db.Close()
+	db.SetMaxIdleConns(0)
+	c.Assert(db.Ping(), IsNil)
+
+	// new connections use the rotated password
+	c.Assert(os.WriteFile(path, []byte("second\n"), 0600), IsNil)
+	s.srv.AddUser("app", "second")
+	c.Assert(db.Ping(), IsNil)
+}
+
+func (s *ServerSuite) TestFailover(c *C) {
+	down, err := testserver.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	down.Close()
+
+	other, err := testserver.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer other.Close()
+
+	dsn := fmt.Sprintf("root@%s,%s,%s", down.Addr(), s.srv.Addr(), other.Addr())
+
+	connector, err := NewConnector(dsn, nil)
+	c.Assert(err, IsNil)
+
+	// the host which is down is skipped
+	for i := 0; i < 2; i++ {
+		conn, err := connector.Connect(context.Background())
+		c.Assert(err, IsNil)
+		c.Assert(conn.(*Conn).Addr().String(), Equals, s.srv.Addr().String())
+		conn.Close()
+	}
+
+	connector, err = NewConnector(dsn+"?hostStrategy=roundRobin", nil)
+	c.Assert(err, IsNil)
+
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		conn, err := connector.Connect(context.Background())
+		c.Assert(err, IsNil)
+		addrs = append(addrs, conn.(*Conn).Addr().String())
+		conn.Close()
+	}
+	c.Assert(addrs, DeepEquals, []string{
+		s.srv.Addr().String(),
+		s.srv.Addr().String(),
+		other.Addr().String(),
+	})
+}
+
+// CR_SERVER_GONE_ERROR or CR_SERVER_LOST
+func assertServerLost(c *C, err error) {
+	var myErr *bridge.MySQLError
+	c.Assert(errors.As(err, &myErr), Equals, true, Commentf("%v", err))
+	c.Assert(myErr.Errno == 2006 || myErr.Errno == 2013, Equals, true, Commentf("%v", err))
+}
+
+func (s *ServerSuite) TestXA(c *C) {
+	stmts := make(chan string, 4)
+	s.srv.Handle(`^XA (START|END|PREPARE|COMMIT) `, func(q *testserver.Query) (*testserver.Result, error) {
+		stmts <- q.SQL
+		return nil, nil
+	})
+	s.srv.Handle(`^XA RECOVER$`, testserver.Rows(
+		[]string{"formatID", "gtrid_length", "bqual_length", "data"},
+		[]interface{}{1, 4, 2, "tx-1b1"},
+	))
+	s.srv.Handle(`^XA ROLLBACK `, testserver.Fail(1397, "XAER_NOTA: Unknown XID"))
+
+	conn, err := s.db.Conn(context.Background())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	ctx := context.Background()
+	xid := XID{Gtrid: "tx-1", Bqual: "b1", FormatID: 1}
+
+	err = conn.Raw(func(driverConn interface{}) error {
+		xa, err := driverConn.(*Conn).XAStart(ctx, xid)
+		c.Assert(err, IsNil)
+		c.Assert(xa.End(ctx), IsNil)
+		c.Assert(xa.Prepare(ctx), IsNil)
+
+		xids, err := driverConn.(*Conn).XARecover(ctx)
+		c.Assert(err, IsNil)
+		c.Assert(xids, DeepEquals, []XID{xid})
+
+		c.Assert(xa.Commit(ctx, false), IsNil)
+		return xa.Rollback(ctx)
+	})
+
+	var myErr *bridge.MySQLError
+	c.Assert(errors.As(err, &myErr), Equals, true)
+	c.Assert(myErr.Errno, Equals, uint16(1397))
+
+	for _, stmt := range []string{"START", "END", "PREPARE", "COMMIT"} {
+		c.Assert(<-stmts, Equals, "XA "+stmt+" X'74782d31', X'6231', 1")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql/driver"
 	"errors"
 	"io"
+	"runtime"
 
 	"github.com/carlsverre/go-libmysql/libmysql/bridge"
 )
@@ -16,12 +17,44 @@ type streamingResult struct {
 	c       *Conn
 	columns []bridge.MySQLField
 	closed  bool
+
+	// rows are fetched in batches unless batching is disabled or the
+	// backend does not support it
+	fetcher batchFetcher
+	batch   *bridge.RowBatch
+	pos     int
+
+	tracker *rowsTracker
+	cleanup runtime.Cleanup
 }
 
-func newStreamingResult(c *Conn) *streamingResult {
+func newStreamingResult(c *Conn, tracker *rowsTracker) *streamingResult {
 	res := new(streamingResult)
 	res.c = c
-	res.columns = c.bridge.Fields()
+	res.tracker = tracker
+	res.columns = c.backend.Fields()
+
+	if fetcher, ok := c.backend.(batchFetcher); ok && c.cfg.RowBatchSize != 1 {
+		if c.batch == nil {
+			size := c.cfg.RowBatchSize
+			if size == 0 {
+				size = defaultRowBatchSize
+			}
+			c.batch = bridge.NewRowBatch(size)
+		}
+		res.fetcher = fetcher
+		res.batch = c.batch
+		res.batch.Reset()
+	}
+
+	// release the connection if the result is dropped without being closed
+	liveStreamingResults.Add(1)
+	stack := bridge.AllocationStack()
+	res.cleanup = runtime.AddCleanup(res, func(b Backend) {
+		bridge.ReportLeak("streamingResult", stack)
+		liveStreamingResults.Add(-1)
+		go b.Flush()
+	}, c.backend)
 
 	return res
 }
@@ -29,7 +62,13 @@ func newStreamingResult(c *Conn) *streamingResult {
 func (r *streamingResult) Close() error {
 	if !r.closed {
 		r.closed = true
-		r.c.bridge.Flush()
+		r.cleanup.Stop()
+		liveStreamingResults.Add(-1)
+		r.tracker.done(nil)
+		if r.batch != nil {
+			r.batch.Reset()
+		}
+		return r.c.backend.Flush()
 	}
 	return nil
 }
@@ -47,7 +86,26 @@ func (r *streamingResult) Next(dest []driver.Value) error {
 		return rowsClosed
 	}
 
-	row, err := r.c.bridge.FetchRow()
+	err := r.c.checkLost(r.next(dest))
+	switch err {
+	case nil:
+		r.c.observeRow(dest)
+		r.tracker.row()
+	case io.EOF:
+		r.tracker.done(nil)
+	default:
+		r.tracker.done(err)
+	}
+
+	return err
+}
+
+func (r *streamingResult) next(dest []driver.Value) error {
+	if r.batch != nil {
+		return r.nextFromBatch(dest)
+	}
+
+	row, err := r.c.backend.FetchRow()
 	if err != nil {
 		return err
 	} else if row == nil {
@@ -64,3 +122,26 @@ func (r *streamingResult) Next(dest []driver.Value) error {
 
 	return err
 }
+
+func (r *streamingResult) nextFromBatch(dest []driver.Value) error {
+	if r.pos >= r.batch.Len() {
+		n, err := r.fetcher.FetchRows(r.batch)
+		if err != nil {
+			return err
+		} else if n == 0 {
+			return io.EOF
+		}
+		r.pos = 0
+	}
+
+	for i := range r.columns {
+		if field := r.batch.Field(r.pos, i); field == nil {
+			dest[i] = nil
+		} else {
+			dest[i] = field
+		}
+	}
+	r.pos++
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package testserver
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	errAccessDenied = errors.New("Access denied")
+)
+
+const (
+	serverVersion = "8.0.0-testserver"
+
+	nativePassword = "mysql_native_password"
+	scrambleLength = 20
+)
+
+// Sends the initial handshake, and authenticates the client's response
+func (s *Server) handshake(p *packetConn, id uint32) (*Session, error) {
+	scramble, err := newScramble()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := []byte{10}
+	buf = append(buf, serverVersion...)
+	buf = append(buf, 0)
+	buf = binary.LittleEndian.AppendUint32(buf, id)
+	buf = append(buf, scramble[:8]...)
+	buf = append(buf, 0)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(serverCapabilities&0xffff))
+	buf = append(buf, defaultCharset)
+	buf = binary.LittleEndian.AppendUint16(buf, serverStatusAutocommit)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(serverCapabilities>>16))
+	buf = append(buf, scrambleLength+1)
+	buf = append(buf, make([]byte, 10)...)
+	buf = append(buf, scramble[8:]...)
+	buf = append(buf, 0)
+	buf = append(buf, nativePassword...)
+	buf = append(buf, 0)
+
+	if err = p.writePacket(buf); err != nil {
+		return nil, err
+	}
+	if err = p.flush(); err != nil {
+		return nil, err
+	}
+
+	packet, err := p.readPacket()
+	if err != nil {
+		return nil, err
+	}
+
+	session, authResponse, plugin, err := parseHandshakeResponse(packet)
+	if err != nil {
+		return nil, err
+	}
+	session.ID = id
+
+	if plugin != nativePassword {
+		// ask the client to switch to the only plugin we support
+		buf = []byte{headerAuthSwitch}
+		buf = append(buf, nativePassword...)
+		buf = append(buf, 0)
+		buf = append(buf, scramble...)
+		buf = append(buf, 0)
+
+		if err = p.writePacket(buf); err != nil {
+			return nil, err
+		}
+		if err = p.flush(); err != nil {
+			return nil, err
+		}
+
+		if authResponse, err = p.readPacket(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !s.authenticate(session.User, scramble, authResponse) {
+		p.writeError(&Error{Code: 1045, State: "28000", Message: "Access denied for user '" + session.User + "'"})
+		p.flush()
+		return nil, errAccessDenied
+	}
+
+	if err = p.writeOK(0, 0); err != nil {
+		return nil, err
+	}
+	return session, p.flush()
+}
+
+func parseHandshakeResponse(packet []byte) (session *Session, authResponse []byte, plugin string, err error) {
+	r := &packetReader{buf: packet}
+	session = &Session{Attrs: make(map[string]string)}
+
+	capabilities := r.uint32()
+	r.next(4)  // max packet size
+	r.uint8()  // charset
+	r.next(23) // filler
+	session.User = r.nulString()
+
+	switch {
+	case capabilities&clientPluginAuthLenencData != 0:
+		authResponse = r.lenencString()
+	case capabilities&clientSecureConnection != 0:
+		authResponse = r.next(int(r.uint8()))
+	default:
+		authResponse = []byte(r.nulString())
+	}
+
+	if capabilities&clientConnectWithDB != 0 {
+		session.Database = r.nulString()
+	}
+
+	plugin = nativePassword
+	if capabilities&clientPluginAuth != 0 && !r.empty() {
+		plugin = r.nulString()
+	}
+
+	if capabilities&clientConnectAttrs != 0 && !r.empty() {
+		attrs := &packetReader{buf: r.lenencString()}
+		for !attrs.empty() && attrs.err == nil {
+			key := attrs.lenencString()
+			session.Attrs[string(key)] = string(attrs.lenencString())
+		}
+		if attrs.err != nil {
+			return nil, nil, "", attrs.err
+		}
+	}
+
+	if r.err != nil {
+		return nil, nil, "", r.err
+	}
+	return session, authResponse, plugin, nil
+}
+
+func (s *Server) authenticate(user string, scramble, authResponse []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.users) == 0 {
+		return true
+	}
+
+	pass, ok := s.users[user]
+	if !ok {
+		return false
+	}
+
+	expected := nativePasswordHash(scramble, pass)
+	return subtle.ConstantTimeCompare(expected, authResponse) == 1
+}
+
+// SHA1(pass) XOR SHA1(scramble + SHA1(SHA1(pass)))
+func nativePasswordHash(scramble []byte, pass string) []byte {
+	if pass == "" {
+		return []byte{}
+	}
+
+	stage1 := sha1.Sum([]byte(pass))
+	stage2 := sha1.Sum(stage1[:])
+
+	h := sha1.New()
+	h.Write(scramble)
+	h.Write(stage2[:])
+	out := h.Sum(nil)
+
+	for i := range out {
+		out[i] ^= stage1[i]
+	}
+	return out
+}
+
+// Returns a random scramble of printable characters, which never contains the
+// NUL byte terminating it in the handshake
+func newScramble() ([]byte, error) {
+	scramble := make([]byte, scrambleLength)
+	if _, err := rand.Read(scramble); err != nil {
+		return nil, err
+	}
+
+	for i, b := range scramble {
+		b = b&0x7f | 0x20
+		if b == 0x7f || b == '$' {
+			b = 'a'
+		}
+		scramble[i] = b
+	}
+	return scramble, nil
+}
@@ -0,0 +1,298 @@
+package testserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/carlsverre/go-libmysql/libmysql/internal/textvalue"
+)
+
+const (
+	maxPacketSize = 1<<24 - 1
+
+	// capability flags
+	clientLongPassword         = 0x00000001
+	clientFoundRows            = 0x00000002
+	clientLongFlag             = 0x00000004
+	clientConnectWithDB        = 0x00000008
+	clientProtocol41           = 0x00000200
+	clientTransactions         = 0x00002000
+	clientSecureConnection     = 0x00008000
+	clientMultiStatements      = 0x00010000
+	clientMultiResults         = 0x00020000
+	clientPluginAuth           = 0x00080000
+	clientConnectAttrs         = 0x00100000
+	clientPluginAuthLenencData = 0x00200000
+
+	serverCapabilities = clientLongPassword | clientFoundRows | clientLongFlag |
+		clientConnectWithDB | clientProtocol41 | clientTransactions |
+		clientSecureConnection | clientMultiStatements | clientMultiResults |
+		clientPluginAuth | clientConnectAttrs | clientPluginAuthLenencData
+
+	serverStatusAutocommit = 0x0002
+
+	// commands
+	comQuit            = 0x01
+	comInitDB          = 0x02
+	comQuery           = 0x03
+	comPing            = 0x0e
+	comResetConnection = 0x1f
+
+	// packet headers
+	headerOK         = 0x00
+	headerEOF        = 0xfe
+	headerERR        = 0xff
+	headerAuthSwitch = 0xfe
+
+	// utf8mb4_general_ci
+	defaultCharset = 45
+
+	columnTypeVarString = 253
+)
+
+var (
+	errMalformedPacket = errors.New("Malformed packet")
+)
+
+// reads and writes packets for a single connection
+type packetConn struct {
+	r   *bufio.Reader
+	w   *bufio.Writer
+	seq byte
+}
+
+func newPacketConn(rw io.ReadWriter) *packetConn {
+	return &packetConn{r: bufio.NewReader(rw), w: bufio.NewWriter(rw)}
+}
+
+// Reads a single logical packet, joining payloads split across several
+// physical packets
+func (p *packetConn) readPacket() ([]byte, error) {
+	var payload []byte
+
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(p.r, header[:]); err != nil {
+			return nil, err
+		}
+
+		length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+		p.seq = header[3] + 1
+
+		start := len(payload)
+		payload = append(payload, make([]byte, length)...)
+		if _, err := io.ReadFull(p.r, payload[start:]); err != nil {
+			return nil, err
+		}
+
+		if length < maxPacketSize {
+			return payload, nil
+		}
+	}
+}
+
+// Buffers a packet, which is sent by the next flush
+func (p *packetConn) writePacket(payload []byte) error {
+	for {
+		length := len(payload)
+		if length > maxPacketSize {
+			length = maxPacketSize
+		}
+
+		header := [4]byte{byte(length), byte(length >> 8), byte(length >> 16), p.seq}
+		p.seq++
+
+		if _, err := p.w.Write(header[:]); err != nil {
+			return err
+		}
+		if _, err := p.w.Write(payload[:length]); err != nil {
+			return err
+		}
+
+		payload = payload[length:]
+		if length < maxPacketSize {
+			return nil
+		}
+	}
+}
+
+func (p *packetConn) flush() error {
+	return p.w.Flush()
+}
+
+func (p *packetConn) writeOK(affectedRows, insertID uint64) error {
+	buf := []byte{headerOK}
+	buf = appendLenencInt(buf, affectedRows)
+	buf = appendLenencInt(buf, insertID)
+	buf = binary.LittleEndian.AppendUint16(buf, serverStatusAutocommit)
+	buf = binary.LittleEndian.AppendUint16(buf, 0)
+	return p.writePacket(buf)
+}
+
+func (p *packetConn) writeEOF() error {
+	buf := []byte{headerEOF, 0, 0}
+	buf = binary.LittleEndian.AppendUint16(buf, serverStatusAutocommit)
+	return p.writePacket(buf)
+}
+
+func (p *packetConn) writeError(err *Error) error {
+	state := err.State
+	if len(state) != 5 {
+		state = "HY000"
+	}
+
+	buf := []byte{headerERR}
+	buf = binary.LittleEndian.AppendUint16(buf, err.Code)
+	buf = append(buf, '#')
+	buf = append(buf, state...)
+	buf = append(buf, err.Message...)
+	return p.writePacket(buf)
+}
+
+func (p *packetConn) writeResultSet(res *Result) error {
+	if err := p.writePacket(appendLenencInt(nil, uint64(len(res.Columns)))); err != nil {
+		return err
+	}
+
+	for _, name := range res.Columns {
+		if err := p.writePacket(columnDefinition(name)); err != nil {
+			return err
+		}
+	}
+	if err := p.writeEOF(); err != nil {
+		return err
+	}
+
+	for _, row := range res.Rows {
+		var buf []byte
+		for _, val := range row {
+			if field := textvalue.Format(val); field == nil {
+				buf = append(buf, 0xfb)
+			} else {
+				buf = appendLenencString(buf, field)
+			}
+		}
+		if err := p.writePacket(buf); err != nil {
+			return err
+		}
+	}
+
+	return p.writeEOF()
+}
+
+func columnDefinition(name string) []byte {
+	buf := appendLenencString(nil, []byte("def"))
+	buf = appendLenencString(buf, nil) // schema
+	buf = appendLenencString(buf, nil) // table
+	buf = appendLenencString(buf, nil) // org_table
+	buf = appendLenencString(buf, []byte(name))
+	buf = appendLenencString(buf, []byte(name))
+	buf = append(buf, 0x0c)
+	buf = binary.LittleEndian.AppendUint16(buf, defaultCharset)
+	buf = binary.LittleEndian.AppendUint32(buf, 1<<16) // column length
+	buf = append(buf, columnTypeVarString)
+	buf = binary.LittleEndian.AppendUint16(buf, 0) // flags
+	buf = append(buf, 0)                           // decimals
+	return append(buf, 0, 0)
+}
+
+func appendLenencInt(buf []byte, n uint64) []byte {
+	switch {
+	case n < 251:
+		return append(buf, byte(n))
+	case n < 1<<16:
+		return binary.LittleEndian.AppendUint16(append(buf, 0xfc), uint16(n))
+	case n < 1<<24:
+		return append(buf, 0xfd, byte(n), byte(n>>8), byte(n>>16))
+	}
+	return binary.LittleEndian.AppendUint64(append(buf, 0xfe), n)
+}
+
+func appendLenencString(buf, s []byte) []byte {
+	return append(appendLenencInt(buf, uint64(len(s))), s...)
+}
+
+// decodes the fields of client packets
+type packetReader struct {
+	buf []byte
+	err error
+}
+
+func (r *packetReader) next(n int) []byte {
+	if r.err != nil || n > len(r.buf) {
+		r.err = errMalformedPacket
+		return nil
+	}
+
+	out := r.buf[:n]
+	r.buf = r.buf[n:]
+	return out
+}
+
+func (r *packetReader) uint8() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *packetReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *packetReader) lenencInt() uint64 {
+	switch first := r.uint8(); first {
+	case 0xfc:
+		if b := r.next(2); b != nil {
+			return uint64(binary.LittleEndian.Uint16(b))
+		}
+	case 0xfd:
+		if b := r.next(3); b != nil {
+			return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16
+		}
+	case 0xfe:
+		if b := r.next(8); b != nil {
+			return binary.LittleEndian.Uint64(b)
+		}
+	default:
+		return uint64(first)
+	}
+	return 0
+}
+
+func (r *packetReader) lenencString() []byte {
+	n := r.lenencInt()
+	if n > uint64(len(r.buf)) {
+		r.err = errMalformedPacket
+		return nil
+	}
+	return r.next(int(n))
+}
+
+// reads up to the next NUL byte, or the end of the packet
+func (r *packetReader) nulString() string {
+	if r.err != nil {
+		return ""
+	}
+
+	for i, b := range r.buf {
+		if b == 0 {
+			out := string(r.buf[:i])
+			r.buf = r.buf[i+1:]
+			return out
+		}
+	}
+
+	out := string(r.buf)
+	r.buf = nil
+	return out
+}
+
+func (r *packetReader) empty() bool {
+	return len(r.buf) == 0
+}
@@ -0,0 +1,311 @@
+// Package testserver is a small in-process MySQL server for integration
+// tests.  It speaks enough of the client/server protocol for libmysqlclient to
+// connect and run queries: the v10 handshake with mysql_native_password
+// authentication, COM_QUERY, COM_INIT_DB, COM_PING and COM_QUIT, and text
+// result sets.  Queries are answered by handlers registered per pattern:
+//
+//	srv, err := testserver.Listen("tcp", "127.0.0.1:0")
+//	defer srv.Close()
+//
+//	srv.Handle(`^SELECT name FROM users`, testserver.Rows([]string{"name"},
+//		[]interface{}{"alice"},
+//		[]interface{}{nil},
+//	))
+//	srv.Handle(`^DELETE`, testserver.Exec(1, 0))
+//
+//	db, err := sql.Open("libmysql", srv.DSN("root", ""))
+package testserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+var (
+	// returned by a Handler to drop the connection without responding
+	ErrDisconnect = errors.New("Disconnect")
+)
+
+// Result is the response to a query.  A result with Columns is sent as a
+// result set, otherwise as an OK packet.
+type Result struct {
+	Columns []string
+	// values are sent as text, nil values as NULL
+	Rows [][]interface{}
+
+	AffectedRows uint64
+	InsertID     uint64
+}
+
+// Error is sent to the client as an ERR packet when returned by a Handler
+type Error struct {
+	Code uint16
+	// SQLSTATE, HY000 when empty
+	State   string
+	Message string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("Error %d: %s", err.Code, err.Message)
+}
+
+// Query is a query received by the server
+type Query struct {
+	SQL string
+	// the submatches of the handler's pattern
+	Match []string
+
+	Session *Session
+}
+
+// Session describes a client connection
+type Session struct {
+	ID       uint32
+	User     string
+	Database string
+	// the connection attributes sent by the client
+	Attrs map[string]string
+}
+
+// Handler answers a query.  Returning an *Error sends it to the client,
+// ErrDisconnect drops the connection, and any other error is sent as an
+// unknown error.
+type Handler func(q *Query) (*Result, error)
+
+// Rows returns a handler which answers with a result set
+func Rows(columns []string, rows ...[]interface{}) Handler {
+	return func(q *Query) (*Result, error) {
+		return &Result{Columns: columns, Rows: rows}, nil
+	}
+}
+
+// Exec returns a handler which answers with an OK packet
+func Exec(affectedRows, insertID uint64) Handler {
+	return func(q *Query) (*Result, error) {
+		return &Result{AffectedRows: affectedRows, InsertID: insertID}, nil
+	}
+}
+
+// Fail returns a handler which answers with an error
+func Fail(code uint16, message string) Handler {
+	return func(q *Query) (*Result, error) {
+		return nil, &Error{Code: code, Message: message}
+	}
+}
+
+// Disconnect returns a handler which drops the connection
+func Disconnect() Handler {
+	return func(q *Query) (*Result, error) {
+		return nil, ErrDisconnect
+	}
+}
+
+type route struct {
+	pattern *regexp.Regexp
+	handler Handler
+}
+
+// Server is a MySQL server listening on a local address
+type Server struct {
+	listener net.Listener
+
+	mu     sync.Mutex
+	routes []route
+	users  map[string]string
+	conns  map[net.Conn]struct{}
+	nextID uint32
+	closed bool
+
+	wg sync.WaitGroup
+}
+
+// Listen starts a server on the provided tcp or unix address, such as
+// "127.0.0.1:0" for a random port
+func Listen(network, address string) (*Server, error) {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		listener: l,
+		users:    make(map[string]string),
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// DSN returns a libmysql DSN which connects to a tcp server
+func (s *Server) DSN(user, pass string) string {
+	host, port, _ := net.SplitHostPort(s.Addr().String())
+
+	auth := user
+	if pass != "" {
+		auth += ":" + pass
+	}
+	return fmt.Sprintf("%s@%s:%s", auth, host, port)
+}
+
+// AddUser requires clients to authenticate.  Until a user is added any user
+// name and password is accepted.
+func (s *Server) AddUser(user, pass string) {
+	s.mu.Lock()
+	s.users[user] = pass
+	s.mu.Unlock()
+}
+
+// Handle registers a handler for the queries matching the regular expression
+// pattern.  Handlers are tried in the order they were registered.
+func (s *Server) Handle(pattern string, handler Handler) {
+	r := route{regexp.MustCompile(pattern), handler}
+
+	s.mu.Lock()
+	s.routes = append(s.routes, r)
+	s.mu.Unlock()
+}
+
+// Close stops the server and drops every open connection
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+// DropConnections closes every open connection, as if the server restarted
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.nextID++
+		id := s.nextID
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn, id)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn, id uint32) {
+	p := newPacketConn(conn)
+
+	session, err := s.handshake(p, id)
+	if err != nil {
+		return
+	}
+
+	for {
+		p.seq = 0
+		packet, err := p.readPacket()
+		if err != nil || len(packet) == 0 {
+			return
+		}
+
+		cmd, arg := packet[0], packet[1:]
+		switch cmd {
+		case comQuit:
+			return
+		case comPing, comResetConnection:
+			err = p.writeOK(0, 0)
+		case comInitDB:
+			session.Database = string(arg)
+			err = p.writeOK(0, 0)
+		case comQuery:
+			if err = s.query(p, session, string(arg)); err == ErrDisconnect {
+				return
+			}
+		default:
+			err = p.writeError(&Error{Code: 1047, State: "08S01", Message: "Unknown command " + strconv.Itoa(int(cmd))})
+		}
+
+		if err != nil || p.flush() != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) query(p *packetConn, session *Session, sql string) error {
+	handler, match := s.route(sql)
+	if handler == nil {
+		return p.writeError(&Error{Code: 1105, Message: "testserver: no handler for query: " + sql})
+	}
+
+	res, err := handler(&Query{SQL: sql, Match: match, Session: session})
+
+	var myErr *Error
+	switch {
+	case err == ErrDisconnect:
+		return err
+	case errors.As(err, &myErr):
+		return p.writeError(myErr)
+	case err != nil:
+		return p.writeError(&Error{Code: 1105, Message: err.Error()})
+	case res == nil || res.Columns == nil:
+		if res == nil {
+			res = &Result{}
+		}
+		return p.writeOK(res.AffectedRows, res.InsertID)
+	}
+
+	return p.writeResultSet(res)
+}
+
+func (s *Server) route(sql string) (Handler, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.routes {
+		if match := r.pattern.FindStringSubmatch(sql); match != nil {
+			return r.handler, match
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,56 @@
+package libmysql
+
+import (
+	"context"
+)
+
+// Tracer starts a span for every exec and query run through ExecContext and
+// QueryContext, such that queries can be tied to the trace in their context.
+// See the oteltracer package for OpenTelemetry.
+type Tracer interface {
+	Start(ctx context.Context, info *SpanInfo) Span
+}
+
+// SpanInfo describes the operation a span covers
+type SpanInfo struct {
+	// OpExec or OpQuery
+	Op Op
+
+	// the query sent to the server, after args were interpolated
+	Query string
+	// the query before args were interpolated
+	RawQuery string
+
+	Database string
+	Host     string
+	Port     int
+}
+
+// Span is ended once its operation completes.  The span of a query covers
+// reading its rows, and ends once they have been read to the end or closed.
+type Span interface {
+	// rows is the number of rows affected by an exec, or read from the
+	// result of a query
+	End(err error, rows int64)
+}
+
+func (c *Conn) startSpan(ctx context.Context, e *Event) Span {
+	if c.tracer == nil {
+		return nil
+	}
+
+	return c.tracer.Start(ctx, &SpanInfo{
+		Op:       e.Op,
+		Query:    e.Query,
+		RawQuery: e.RawQuery,
+		Database: c.cfg.Database,
+		Host:     c.addr.Host,
+		Port:     c.addr.Port,
+	})
+}
+
+func endSpan(span Span, err error, rows int64) {
+	if span != nil {
+		span.End(err, rows)
+	}
+}
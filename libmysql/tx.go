@@ -0,0 +1,68 @@
+package libmysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// implements the sql/driver Tx interface
+type tx struct {
+	c *Conn
+}
+
+func (t *tx) Commit() error {
+	_, err := t.c.exec(context.Background(), "COMMIT", nil)
+	return err
+}
+
+func (t *tx) Rollback() error {
+	_, err := t.c.exec(context.Background(), "ROLLBACK", nil)
+	return err
+}
+
+// implements the sql/driver Conn interface
+func (c *Conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// implements the sql/driver ConnBeginTx interface
+func (c *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		level, err := isolationLevel(sql.IsolationLevel(opts.Isolation))
+		if err != nil {
+			return nil, err
+		}
+
+		// applies to the next transaction only
+		if _, err = c.exec(ctx, "SET TRANSACTION ISOLATION LEVEL "+level, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	start := "START TRANSACTION"
+	if opts.ReadOnly {
+		start += " READ ONLY"
+	}
+
+	if _, err := c.exec(ctx, start, nil); err != nil {
+		return nil, err
+	}
+
+	return &tx{c: c}, nil
+}
+
+func isolationLevel(level sql.IsolationLevel) (string, error) {
+	switch level {
+	case sql.LevelReadUncommitted:
+		return "READ UNCOMMITTED", nil
+	case sql.LevelReadCommitted:
+		return "READ COMMITTED", nil
+	case sql.LevelRepeatableRead:
+		return "REPEATABLE READ", nil
+	case sql.LevelSerializable:
+		return "SERIALIZABLE", nil
+	}
+	return "", fmt.Errorf("Unsupported isolation level %s", level)
+}
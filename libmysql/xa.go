@@ -0,0 +1,196 @@
+package libmysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// the longest global transaction id and branch qualifier MySQL accepts
+const maxXIDPartLen = 64
+
+var (
+	errInvalidXID   = errors.New("Invalid XID, the global transaction id must be 1 to 64 bytes and the branch qualifier at most 64 bytes")
+	errXARecoverRow = errors.New("Failed to parse XA RECOVER result")
+)
+
+// XID identifies a branch of an XA transaction.  The ids may hold any bytes,
+// they are sent as hex literals.
+type XID struct {
+	// the global transaction id, shared by every branch
+	Gtrid string
+	// the branch qualifier, which may be empty
+	Bqual string
+	// identifies the format of Gtrid and Bqual to the transaction manager.
+	// It is always sent, so the zero value is format 0 rather than the 1
+	// MySQL uses for XIDs given without one, such as by the mysql cli.
+	FormatID uint32
+}
+
+func (x XID) String() string {
+	return fmt.Sprintf("%d:%x:%x", x.FormatID, x.Gtrid, x.Bqual)
+}
+
+// XATx is an XA transaction branch started by Conn.XAStart.  Statements run
+// on the connection between XAStart and End are part of the branch.
+//
+// database/sql doesn't know about XA transactions, so the connection must be
+// reached with sql.Conn's Raw, and the branch ended before it is released:
+//
+//	err := conn.Raw(func(driverConn interface{}) error {
+//		c := driverConn.(*libmysql.Conn)
+//		xa, err := c.XAStart(ctx, xid)
+//		if err != nil {
+//			return err
+//		}
+//		if _, err = c.Exec("INSERT INTO x VALUES (%s)", []driver.Value{1}); err != nil {
+//			xa.End(ctx)
+//			xa.Rollback(ctx)
+//			return err
+//		}
+//		if err = xa.End(ctx); err != nil {
+//			return err
+//		}
+//		return xa.Prepare(ctx)
+//	})
+type XATx struct {
+	c   *Conn
+	xid XID
+}
+
+// XID returns the branch's id
+func (t *XATx) XID() XID {
+	return t.xid
+}
+
+// End ends the branch, after which it can be prepared
+func (t *XATx) End(ctx context.Context) error {
+	return t.c.XAEnd(ctx, t.xid)
+}
+
+// Prepare prepares the ended branch to be committed
+func (t *XATx) Prepare(ctx context.Context) error {
+	return t.c.XAPrepare(ctx, t.xid)
+}
+
+// Commit commits the prepared branch, or with onePhase prepares and commits
+// the ended branch in one step
+func (t *XATx) Commit(ctx context.Context, onePhase bool) error {
+	return t.c.XACommit(ctx, t.xid, onePhase)
+}
+
+// Rollback rolls back the ended or prepared branch
+func (t *XATx) Rollback(ctx context.Context) error {
+	return t.c.XARollback(ctx, t.xid)
+}
+
+// XAStart starts an XA transaction branch on the connection
+func (c *Conn) XAStart(ctx context.Context, xid XID) (*XATx, error) {
+	if err := c.execXA(ctx, "XA START", xid, ""); err != nil {
+		return nil, err
+	}
+	return &XATx{c: c, xid: xid}, nil
+}
+
+// XAEnd ends the branch started on the connection
+func (c *Conn) XAEnd(ctx context.Context, xid XID) error {
+	return c.execXA(ctx, "XA END", xid, "")
+}
+
+// XAPrepare prepares the ended branch to be committed
+func (c *Conn) XAPrepare(ctx context.Context, xid XID) error {
+	return c.execXA(ctx, "XA PREPARE", xid, "")
+}
+
+// XACommit commits a prepared branch, which may have been started on
+// another connection, or with onePhase prepares and commits the branch ended
+// on this connection in one step
+func (c *Conn) XACommit(ctx context.Context, xid XID, onePhase bool) error {
+	suffix := ""
+	if onePhase {
+		suffix = " ONE PHASE"
+	}
+	return c.execXA(ctx, "XA COMMIT", xid, suffix)
+}
+
+// XARollback rolls back a prepared branch, which may have been started on
+// another connection, or the branch ended on this connection
+func (c *Conn) XARollback(ctx context.Context, xid XID) error {
+	return c.execXA(ctx, "XA ROLLBACK", xid, "")
+}
+
+func (c *Conn) execXA(ctx context.Context, stmt string, xid XID, suffix string) error {
+	if len(xid.Gtrid) == 0 || len(xid.Gtrid) > maxXIDPartLen || len(xid.Bqual) > maxXIDPartLen {
+		return errInvalidXID
+	}
+
+	args := []driver.Value{[]byte(xid.Gtrid), []byte(xid.Bqual), int64(xid.FormatID)}
+	_, err := c.exec(ctx, stmt+" %s, %s, %s"+suffix, args)
+	return err
+}
+
+// XARecover returns the branches which are prepared on the server, such as
+// those left behind by a transaction manager which failed before committing
+// or rolling them back
+func (c *Conn) XARecover(ctx context.Context) ([]XID, error) {
+	rows, err := c.query(ctx, "XA RECOVER", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// formatID, gtrid_length, bqual_length, data
+	cols := rows.Columns()
+	if len(cols) != 4 {
+		return nil, errXARecoverRow
+	}
+
+	var xids []XID
+	dest := make([]driver.Value, len(cols))
+	for {
+		err = rows.Next(dest)
+		if err == io.EOF {
+			return xids, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		xid, err := parseXARecoverRow(dest)
+		if err != nil {
+			return nil, err
+		}
+		xids = append(xids, xid)
+	}
+}
+
+// data holds the gtrid followed by the bqual
+func parseXARecoverRow(row []driver.Value) (XID, error) {
+	var nums [3]uint64
+	for i := range nums {
+		field, ok := row[i].([]byte)
+		if !ok {
+			return XID{}, errXARecoverRow
+		}
+
+		n, err := strconv.ParseUint(string(field), 10, 32)
+		if err != nil {
+			return XID{}, errXARecoverRow
+		}
+		nums[i] = n
+	}
+
+	data, ok := row[3].([]byte)
+	gtridLen, bqualLen := int(nums[1]), int(nums[2])
+	if !ok || len(data) != gtridLen+bqualLen {
+		return XID{}, errXARecoverRow
+	}
+
+	return XID{
+		Gtrid:    string(data[:gtridLen]),
+		Bqual:    string(data[gtridLen:]),
+		FormatID: uint32(nums[0]),
+	}, nil
+}
@@ -0,0 +1,96 @@
+package libmysql
+
+import (
+	"context"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type XASuite struct {
+	fake *Fake
+	conn *Conn
+}
+
+var _ = Suite(&XASuite{})
+
+func (s *XASuite) SetUpTest(c *C) {
+	s.fake = NewFake()
+
+	connector, err := NewConnector("root@localhost", s.fake.NewBackend)
+	c.Assert(err, IsNil)
+
+	conn, err := connector.Connect(context.Background())
+	c.Assert(err, IsNil)
+	s.conn = conn.(*Conn)
+}
+
+func (s *XASuite) TearDownTest(c *C) {
+	s.conn.Close()
+}
+
+func (s *XASuite) TestTwoPhase(c *C) {
+	ctx := context.Background()
+	xid := XID{Gtrid: "order-42", Bqual: "it's\x00", FormatID: 1}
+
+	s.fake.Expect(`^XA START X'6f726465722d3432', X'6974277300', 1$`)
+	s.fake.Expect(`^XA END X'6f726465722d3432', X'6974277300', 1$`)
+	s.fake.Expect(`^XA PREPARE X'6f726465722d3432', X'6974277300', 1$`)
+	s.fake.Expect(`^XA COMMIT X'6f726465722d3432', X'6974277300', 1$`)
+	s.fake.Expect(`^XA START X'6f726465722d3432', X'', 0$`)
+	s.fake.Expect(`^XA END X'6f726465722d3432', X'', 0$`)
+	s.fake.Expect(`^XA COMMIT X'6f726465722d3432', X'', 0 ONE PHASE$`)
+	s.fake.Expect(`^XA ROLLBACK X'6f726465722d3432', X'', 0$`)
+
+	xa, err := s.conn.XAStart(ctx, xid)
+	c.Assert(err, IsNil)
+	c.Assert(xa.XID(), Equals, xid)
+	c.Assert(xa.End(ctx), IsNil)
+	c.Assert(xa.Prepare(ctx), IsNil)
+	c.Assert(xa.Commit(ctx, false), IsNil)
+
+	xa, err = s.conn.XAStart(ctx, XID{Gtrid: "order-42"})
+	c.Assert(err, IsNil)
+	c.Assert(xa.End(ctx), IsNil)
+	c.Assert(xa.Commit(ctx, true), IsNil)
+	c.Assert(s.conn.XARollback(ctx, XID{Gtrid: "order-42"}), IsNil)
+
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}
+
+func (s *XASuite) TestInvalidXID(c *C) {
+	ctx := context.Background()
+	long := strings.Repeat("x", 65)
+
+	_, err := s.conn.XAStart(ctx, XID{})
+	c.Assert(err, Equals, errInvalidXID)
+	_, err = s.conn.XAStart(ctx, XID{Gtrid: long})
+	c.Assert(err, Equals, errInvalidXID)
+	c.Assert(s.conn.XACommit(ctx, XID{Gtrid: "a", Bqual: long}, false), Equals, errInvalidXID)
+}
+
+func (s *XASuite) TestRecover(c *C) {
+	cols := []string{"formatID", "gtrid_length", "bqual_length", "data"}
+	s.fake.Expect(`^XA RECOVER$`).ReturnRows(cols,
+		[]interface{}{1, 8, 3, "order-42db1"},
+		[]interface{}{7, 2, 0, "\x00\xff"},
+	)
+	s.fake.Expect(`^XA RECOVER$`).ReturnRows(cols)
+	s.fake.Expect(`^XA RECOVER$`).ReturnRows(cols, []interface{}{1, 8, 3, "short"})
+
+	xids, err := s.conn.XARecover(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(xids, DeepEquals, []XID{
+		{Gtrid: "order-42", Bqual: "db1", FormatID: 1},
+		{Gtrid: "\x00\xff", FormatID: 7},
+	})
+
+	xids, err = s.conn.XARecover(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(xids, HasLen, 0)
+
+	_, err = s.conn.XARecover(context.Background())
+	c.Assert(err, Equals, errXARecoverRow)
+
+	c.Assert(s.fake.ExpectationsMet(), IsNil)
+}